@@ -0,0 +1,160 @@
+// Worker executing the bots of the Analysis Bots Platform.
+//
+// On the first start the worker is registered using the user's worker
+// registration token (see the user page of the platform):
+//
+//	abp-worker -server platform:4242 -user-token <token> -name my-worker
+//
+// The token of the registered worker is stored in the token file and used for
+// all further starts. The worker runs until it receives SIGINT or SIGTERM.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"github.com/AnalysisBotsPlatform/platform/worker/client"
+	"io/ioutil"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+func main() {
+	server := flag.String("server", "localhost:4242",
+		"address of the platform's worker port")
+	token_file := flag.String("token-file", "worker.token",
+		"file storing the token of the worker")
+	user_token := flag.String("user-token", "",
+		"worker registration token of the user (registers a new worker)")
+	name := flag.String("name", "", "name of a new worker")
+	shared := flag.Bool("shared", false,
+		"register a new worker as shared worker (admins only)")
+	labels := flag.String("labels", "",
+		"comma-separated labels advertised by the worker (e.g. arch=amd64)")
+	capacity := flag.Int("capacity", 1,
+		"number of tasks executed concurrently")
+	executor := flag.String("executor", "docker",
+		"how bots are run: docker or local")
+	bots_dir := flag.String("bots-dir", "bots",
+		"directory of the bots' executables (local executor)")
+	work_dir := flag.String("work-dir", os.TempDir(),
+		"directory where projects are cloned to")
+	use_tls := flag.Bool("tls", false, "connect to the worker port using TLS")
+	tls_ca := flag.String("tls-ca", "",
+		"CA certificates verifying the platform (default: system CAs)")
+	tls_cert := flag.String("tls-cert", "", "client certificate")
+	tls_key := flag.String("tls-key", "", "key of the client certificate")
+	flag.Parse()
+
+	var tls_config *tls.Config
+	if *use_tls {
+		var err error
+		if tls_config, err = loadTLSConfig(*server, *tls_ca, *tls_cert,
+			*tls_key); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	var worker_labels []string
+	if *labels != "" {
+		for _, label := range strings.Split(*labels, ",") {
+			worker_labels = append(worker_labels, strings.TrimSpace(label))
+		}
+	}
+
+	// register a new worker if requested
+	if *user_token != "" {
+		if *name == "" {
+			log.Fatal("A name is required to register a new worker!")
+		}
+		token, err := client.Register(*server, tls_config, *user_token, *name,
+			*shared, worker_labels, *capacity)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := ioutil.WriteFile(*token_file, []byte(token+"\n"),
+			0600); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Worker registered, token stored in %s\n", *token_file)
+	}
+
+	token, err := ioutil.ReadFile(*token_file)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	worker := &client.Client{
+		Address:        *server,
+		TLS_config:     tls_config,
+		Token:          strings.TrimSpace(string(token)),
+		Labels:         worker_labels,
+		Work_directory: *work_dir,
+		Capacity:       *capacity,
+	}
+	switch *executor {
+	case "docker":
+		worker.Executor = &client.DockerExecutor{}
+	case "local":
+		worker.Executor = &client.LocalExecutor{Bots_directory: *bots_dir}
+	default:
+		log.Fatalf("Unknown executor %q!", *executor)
+	}
+	if err := os.MkdirAll(*work_dir, 0755); err != nil {
+		log.Fatal(err)
+	}
+
+	// finish the current tasks and unregister on termination
+	stop := make(chan struct{})
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		fmt.Println("Stopping worker ...")
+		close(stop)
+	}()
+
+	fmt.Printf("Worker started, connecting to %s\n", *server)
+	if err := worker.Run(stop); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("... worker terminated")
+}
+
+// Helper to set up TLS. The platform's certificate is verified using the given
+// CA certificates or the system's CAs. A client certificate is presented if
+// given.
+func loadTLSConfig(server, ca_file, cert_file, key_file string) (*tls.Config,
+	error) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{ServerName: host}
+
+	if ca_file != "" {
+		pem, err := ioutil.ReadFile(ca_file)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("No certificate found in %s!", ca_file)
+		}
+		config.RootCAs = pool
+	}
+	if cert_file != "" || key_file != "" {
+		certificate, err := tls.LoadX509KeyPair(cert_file, key_file)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	return config, nil
+}
@@ -10,13 +10,15 @@ import (
 	"errors"
 	"fmt"
 	"github.com/AnalysisBotsPlatform/platform/db"
+	"github.com/AnalysisBotsPlatform/platform/storage"
 	"github.com/AnalysisBotsPlatform/platform/utils"
 	"github.com/AnalysisBotsPlatform/platform/worker"
-	"github.com/gorhill/cronexpr"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/sessions"
 	"html/template"
+	"io"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/url"
 	"os"
@@ -64,6 +66,23 @@ const cache_path_var = "CACHE_PATH"
 
 var cache_path = os.Getenv(cache_path_var)
 
+// Storage of Git patches and artifacts (optional)
+const storage_backend_var = "STORAGE_BACKEND"
+const s3_endpoint_var = "S3_ENDPOINT"
+const s3_region_var = "S3_REGION"
+const s3_bucket_var = "S3_BUCKET"
+const s3_access_key_var = "S3_ACCESS_KEY"
+const s3_secret_key_var = "S3_SECRET_KEY"
+const s3_path_style_var = "S3_PATH_STYLE"
+
+var storage_backend = os.Getenv(storage_backend_var)
+var s3_endpoint = os.Getenv(s3_endpoint_var)
+var s3_region = os.Getenv(s3_region_var)
+var s3_bucket = os.Getenv(s3_bucket_var)
+var s3_access_key = os.Getenv(s3_access_key_var)
+var s3_secret_key = os.Getenv(s3_secret_key_var)
+var s3_path_style = os.Getenv(s3_path_style_var)
+
 // Administrator
 const admin_user_var = "ADMIN_USER"
 
@@ -85,9 +104,34 @@ const worker_port_var = "WORKER_PORT"
 
 var worker_port = os.Getenv(worker_port_var)
 
+// Transport security of the worker service (optional)
+const worker_tls_cert_var = "WORKER_TLS_CERT"
+const worker_tls_key_var = "WORKER_TLS_KEY"
+const worker_tls_client_ca_var = "WORKER_TLS_CLIENT_CA"
+
+var worker_tls_cert = os.Getenv(worker_tls_cert_var)
+var worker_tls_key = os.Getenv(worker_tls_key_var)
+var worker_tls_client_ca = os.Getenv(worker_tls_client_ca_var)
+
+// Bounds of the tasks' timeouts (optional)
+const task_timeout_min_var = "TASK_TIMEOUT_MIN"
+const task_timeout_max_var = "TASK_TIMEOUT_MAX"
+
+var task_timeout_min = os.Getenv(task_timeout_min_var)
+var task_timeout_max = os.Getenv(task_timeout_max_var)
+
 // webhook path
 const webhook_subpath = "webhook"
 
+// clone proxy path
+const clone_subpath = "clone"
+
+// Headers passed through by the clone proxy
+var clone_request_headers = []string{"Accept", "Content-Type",
+	"Content-Encoding", "Git-Protocol", "User-Agent"}
+var clone_response_headers = []string{"Content-Type", "Content-Encoding",
+	"Cache-Control", "Expires", "Pragma"}
+
 // Id regex
 const id_regex = "0|[1-9][0-9]*"
 
@@ -117,17 +161,52 @@ var templates = template.Must(template.ParseGlob(
 // Application constants
 //
 
-// Interval in seconds for canceling timed over tasks
+// Interval in seconds for canceling timed over tasks and failing tasks of lost
+// workers
 const time_check_interval = 10
 
 // Number of character used to communicate with GitHub (secret message).
 const state_size = 32
 
+// Number of retries of a Bot's failed execution if none is specified.
+const default_max_retries = 2
+
+// Maximal duration in seconds of a Bot's execution if none is specified.
+const default_timeout = 600
+
+// Bounds in seconds of the tasks' timeouts if TASK_TIMEOUT_MIN or
+// TASK_TIMEOUT_MAX is not set.
+const (
+	default_min_timeout = 60
+	default_max_timeout = 3600
+)
+
+// Number of executions and connections shown on the page of a worker.
+const worker_history_size = 20
+
+// Number of queue entries shown per page
+const queue_page_size = 50
+
+// Interval in seconds after which a comment is sent to clients following the
+// output of a task in order to keep the connection alive.
+const output_keepalive_interval = 15
+
 // Context settings
 var error_counter = 0
 var error_map = make(map[string]interface{})
 var error_guard *sync.RWMutex = &sync.RWMutex{}
 
+// Bounds in seconds of the tasks' timeouts (see `parseTimeoutBounds`)
+var min_task_timeout, max_task_timeout int64
+
+// Errors of `editTaskGroup` that are not caused by invalid input
+var (
+	unknownTaskGroup = errors.New("The task id was not known and thus " +
+		"could not have been edited.")
+	hookUpdateFailure = errors.New("The webhook of the project could not " +
+		"have been updated on GitHub!")
+)
+
 //
 // Entry point
 //
@@ -176,6 +255,39 @@ var error_guard *sync.RWMutex = &sync.RWMutex{}
 // - APP_SSL_MODE: True if the platfrom is supposed to use https instead of
 // http.
 //
+// Optionally, Git patches and artifacts can be kept in an S3-compatible object
+// storage instead of CACHE_PATH (see `openStorage`):
+//
+// - STORAGE_BACKEND: Either "filesystem" (default) or "s3".
+//
+// - S3_ENDPOINT: Base URL of the object storage, e.g. http://localhost:9000.
+//
+// - S3_REGION: Region of the bucket (default: us-east-1).
+//
+// - S3_BUCKET: Name of the bucket where the files are stored.
+//
+// - S3_ACCESS_KEY, S3_SECRET_KEY: Credentials used to access the bucket.
+//
+// - S3_PATH_STYLE: False if the bucket is addressed via the host name instead
+// of the path (default: true).
+//
+// Optionally, the worker port can be secured by TLS:
+//
+// - WORKER_TLS_CERT, WORKER_TLS_KEY: PEM encoded certificate and key of the
+// worker port. TLS is enabled if both are set.
+//
+// - WORKER_TLS_CLIENT_CA: PEM encoded certificate authorities issuing the
+// client certificates of the workers. If set workers must present a client
+// certificate. A worker is bound to the first certificate it presents.
+//
+// Sending SIGHUP reloads these files, e.g. after the certificates were renewed.
+//
+// Optionally, the timeouts of tasks can be bounded:
+//
+// - TASK_TIMEOUT_MIN, TASK_TIMEOUT_MAX: Minimal and maximal duration in seconds
+// of a task's execution (default: 60 and 3600). Timeouts of Bots and task
+// groups outside of these bounds are adjusted.
+//
 // In case some of the variables are missing a corresponding message is prompted
 // to the standard output and the function terminates without any further
 // action.
@@ -191,7 +303,8 @@ var error_guard *sync.RWMutex = &sync.RWMutex{}
 //
 // Then a new goroutine listening on that channel is executed concurrently.
 // Whenever something is received on the `sigs` channel the database connection
-// is closed and the system exits with the status code 0.
+// is closed and the system exits with the status code 0. Likewise SIGHUP
+// triggers reloading the certificates of the worker port.
 //
 // Finally, the `ListenAndServe` function of the http package is called in order
 // to listen on port APP_PORT for incoming http requests. The router used to
@@ -240,6 +353,11 @@ func Start() {
 		return
 	}
 
+	if err := parseTimeoutBounds(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
 	// initialize database connection
 	fmt.Println("Controller start ...")
 	if err := db.OpenDB(db_host, db_user, db_pass, db_name); err != nil {
@@ -262,17 +380,36 @@ func Start() {
 				return
 			}
 		}
-		if err := worker.Init(worker_port, cache_path); err != nil {
+		store, err := openStorage(dir)
+		if err != nil {
+			fmt.Println("Storage cannot be opened!")
+			fmt.Println(err)
+			return
+		}
+		var tls_files *worker.TLSFiles
+		if worker_tls_cert != "" || worker_tls_key != "" {
+			tls_files = &worker.TLSFiles{
+				Certificate: worker_tls_cert,
+				Key:         worker_tls_key,
+				Client_CA:   worker_tls_client_ca,
+			}
+		}
+		if err := worker.Init(worker_port, cache_path, store, tls_files,
+			applicationURL()+clone_subpath, min_task_timeout,
+			max_task_timeout); err != nil {
 			fmt.Println(err)
 			return
 		}
 	}
 
-	// goroutine for cancelation of tasks
+	// goroutine for cancelation of tasks, reclaiming tasks of lost workers and
+	// pruning the streamed output of finished tasks
 	ticker := time.NewTicker(time.Second * time_check_interval)
 	go func() {
 		for range ticker.C {
 			worker.CancelTimedOverTasks()
+			worker.ReapExpiredLeases()
+			worker.PruneTaskOutput()
 		}
 	}()
 
@@ -288,6 +425,20 @@ func Start() {
 		os.Exit(0)
 	}()
 
+	// reload the certificates of the worker port on SIGHUP
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	go func() {
+		for range reloads {
+			if err := worker.ReloadTLS(); err != nil {
+				fmt.Println("Worker certificates cannot be reloaded!")
+				fmt.Println(err)
+			} else {
+				fmt.Println("Worker certificates reloaded")
+			}
+		}
+	}()
+
 	// listen on port APP_PORT to handle http requests
 	if err := http.ListenAndServe(fmt.Sprintf(":%s", application_port),
 		initRoutes()); err != nil {
@@ -300,6 +451,54 @@ func Start() {
 // Helper functions
 //
 
+// Opens the storage of Git patches and artifacts as configured by the
+// STORAGE_BACKEND variable. By default the files are kept below `cache_dir`.
+func openStorage(cache_dir string) (storage.Store, error) {
+	switch storage_backend {
+	case "", "filesystem":
+		return storage.NewFileStore(cache_dir)
+	case "s3":
+		return storage.NewS3Store(s3_endpoint, s3_region, s3_bucket,
+			s3_access_key, s3_secret_key, s3_path_style != "false")
+	default:
+		return nil, fmt.Errorf("Unknown storage backend %q!", storage_backend)
+	}
+}
+
+// Returns the URL under which the application is reachable (including the
+// subdirectory and a trailing slash).
+func applicationURL() string {
+	ssl := ""
+	if is_ssl, _ := strconv.ParseBool(application_ssl_mode); is_ssl {
+		ssl = "s"
+	}
+
+	return fmt.Sprintf("http%s://%s%s", ssl, application_host,
+		application_subdirectory)
+}
+
+// Sets or clears the draining state of the user's worker (see
+// `db.SetWorkerDraining`). If `deregister` is set the draining worker is deleted
+// as soon as it finished its current task.
+func drainWorker(user_token, worker_token string, draining,
+	deregister bool) (*db.Worker, error) {
+	drained, err := db.SetWorkerDraining(user_token, worker_token, draining,
+		deregister)
+	if err != nil {
+		return nil, err
+	}
+
+	if draining {
+		worker.DrainWorker(worker_token)
+		if deregister {
+			// delete the worker right away if it is idle
+			db.DeleteDrainedWorker(worker_token)
+		}
+	}
+
+	return drained, nil
+}
+
 // Register all routes and their handlers.
 func initRoutes() (rootRouter *mux.Router) {
 	// declare routers
@@ -312,6 +511,8 @@ func initRoutes() (rootRouter *mux.Router) {
 		application_subdirectory)).Subrouter()
 	apiRouter := rootRouter.PathPrefix(fmt.Sprintf("%sapi",
 		application_subdirectory)).Subrouter()
+	workerRouter := rootRouter.PathPrefix(fmt.Sprintf("%sworker/%s",
+		application_subdirectory, worker.HTTP_API_version)).Subrouter()
 
 	// register handlers for http requests
 
@@ -337,9 +538,33 @@ func initRoutes() (rootRouter *mux.Router) {
 	rootRouter.HandleFunc(fmt.Sprintf("%suser/worker/deregister",
 		application_subdirectory),
 		makeHandler(makeTokenHandler(handleUserDegegisterWorker)))
+	rootRouter.HandleFunc(fmt.Sprintf("%suser/worker/drain",
+		application_subdirectory),
+		makeHandler(makeTokenHandler(handleUserDrainWorker)))
+	rootRouter.HandleFunc(fmt.Sprintf("%suser/worker/reset_certificate",
+		application_subdirectory),
+		makeHandler(makeTokenHandler(handleUserResetWorkerCertificate)))
+	rootRouter.HandleFunc(fmt.Sprintf("%suser/worker/{wid:%s}",
+		application_subdirectory, id_regex),
+		makeHandler(makeTokenHandler(handleUserWorker)))
+	rootRouter.HandleFunc(fmt.Sprintf("%sadmin/workers",
+		application_subdirectory),
+		makeHandler(makeTokenHandler(handleAdminWorkers)))
+	rootRouter.HandleFunc(fmt.Sprintf("%sadmin/queue",
+		application_subdirectory),
+		makeHandler(makeTokenHandler(handleAdminQueue)))
+	rootRouter.HandleFunc(fmt.Sprintf("%sadmin/users",
+		application_subdirectory),
+		makeHandler(makeTokenHandler(handleAdminUsers))).Methods("GET")
+	rootRouter.HandleFunc(fmt.Sprintf("%sadmin/users",
+		application_subdirectory),
+		makeHandler(makeTokenHandler(handleAdminUsersPost))).Methods("POST")
 	rootRouter.HandleFunc(fmt.Sprintf("%scache/patches/{patch:.*\\.patch}",
 		application_subdirectory),
 		makeHandler(makeTokenHandler(handlePatchDownload)))
+	rootRouter.HandleFunc(fmt.Sprintf(
+		"%s%s/{tid:%s}.git/{service:info/refs|git-upload-pack}",
+		application_subdirectory, clone_subpath, id_regex), handleCloneProxy)
 	rootRouter.HandleFunc(fmt.Sprintf("%snewpullrequest/{tid:.%s}",
 		application_subdirectory, id_regex),
 		makeHandler(makeTokenHandler(handlePullRequestNew)))
@@ -373,6 +598,9 @@ func initRoutes() (rootRouter *mux.Router) {
 		makeHandler(makeTokenHandler(handleProjects)))
 	projectsRouter.HandleFunc(fmt.Sprintf("/{pid:%s}", id_regex),
 		makeHandler(makeTokenHandler(handleProjectsPid)))
+	projectsRouter.HandleFunc(fmt.Sprintf("/{pid:%s}/routing", id_regex),
+		makeHandler(makeTokenHandler(handleProjectsPidRouting))).
+		Methods("POST")
 	projectsRouter.HandleFunc(fmt.Sprintf("/{pid:%s}/newtask", id_regex),
 		makeHandler(makeTokenHandler(handleProjectsPidNewtask)))
 	projectsRouter.HandleFunc(
@@ -393,12 +621,37 @@ func initRoutes() (rootRouter *mux.Router) {
 
 	// tasks
 	tasksRouter.HandleFunc("/", makeHandler(makeTokenHandler(handleTasks)))
+	tasksRouter.HandleFunc("/queue",
+		makeHandler(makeTokenHandler(handleTasksQueue)))
+	tasksRouter.HandleFunc("/schedule_preview",
+		makeHandler(makeTokenHandler(handleTasksSchedulePreview)))
 	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}", id_regex),
 		makeHandler(makeTokenHandler(handleTasksTid)))
 	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/cancel", id_regex),
 		makeHandler(makeTokenHandler(handleTasksTidCancel)))
 	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/cancel_group", id_regex),
 		makeHandler(makeTokenHandler(handleTasksTidCancelGroup)))
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/edit", id_regex),
+		makeHandler(makeTokenHandler(handleTasksTidEditForm))).
+		Methods("GET")
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/edit", id_regex),
+		makeHandler(makeTokenHandler(handleTasksTidEditPost))).
+		Methods("POST")
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/pause", id_regex),
+		makeHandler(makeTokenHandler(handleTasksTidPause)))
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/resume", id_regex),
+		makeHandler(makeTokenHandler(handleTasksTidResume)))
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/output", id_regex),
+		makeHandler(makeTokenHandler(handleTasksTidOutput)))
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/artifacts/{aid:%s}",
+		id_regex, id_regex),
+		makeHandler(makeTokenHandler(handleArtifactDownload)))
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/settings", id_regex),
+		makeHandler(makeTokenHandler(handleTasksTidSettingsForm))).
+		Methods("GET")
+	tasksRouter.HandleFunc(fmt.Sprintf("/{tid:%s}/settings", id_regex),
+		makeHandler(makeTokenHandler(handleTasksTidSettingsPost))).
+		Methods("POST")
 
 	// API
 	apiRouter.HandleFunc("/bot", makeAPIHandler(handleAPIPostBot)).
@@ -413,8 +666,27 @@ func initRoutes() (rootRouter *mux.Router) {
 		Methods("POST")
 	apiRouter.HandleFunc("/task", makeAPIHandler(handleAPIDeleteTask)).
 		Methods("DELETE")
+	apiRouter.HandleFunc("/task/output",
+		makeAPIHandler(handleAPIGetTaskOutput)).Methods("GET")
+	apiRouter.HandleFunc("/task/artifacts",
+		makeAPIHandler(handleAPIGetArtifacts)).Methods("GET")
+	apiRouter.HandleFunc("/task/artifact",
+		makeAPIHandler(handleAPIGetArtifact)).Methods("GET")
 	apiRouter.HandleFunc("/tasks", makeAPIHandler(handleAPIGetTasks)).
 		Methods("GET")
+	apiRouter.HandleFunc("/queue", makeAPIHandler(handleAPIGetQueue)).
+		Methods("GET")
+	apiRouter.HandleFunc("/worker/drain",
+		makeAPIHandler(handleAPIPostWorkerDrain)).Methods("POST")
+	apiRouter.HandleFunc("/taskgroup", makeAPIHandler(handleAPIGetTaskGroup)).
+		Methods("GET")
+	apiRouter.HandleFunc("/taskgroup", makeAPIHandler(handleAPIPostTaskGroup)).
+		Methods("POST")
+	apiRouter.HandleFunc("/taskgroup/edit",
+		makeAPIHandler(handleAPIPostTaskGroupEdit)).Methods("POST")
+
+	// worker API
+	worker.RegisterHTTPRoutes(workerRouter)
 
 	return
 }
@@ -642,6 +914,483 @@ func getTokenOrRedirect(w http.ResponseWriter, r *http.Request,
 	return "", errors.New("User token not available!")
 }
 
+// Returns the name of the user which is recorded as the actor of the user's
+// actions (e.g. canceling a task).
+func actorName(token string) string {
+	user, err := db.GetUser(token)
+	if err != nil {
+		return ""
+	}
+	return user.User_name
+}
+
+// Parses the number of retries of a Bot. If no value is given the default
+// number of retries is used.
+func parseRetries(value string) (int64, error) {
+	if value == "" {
+		return default_max_retries, nil
+	}
+	retries, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || retries < 0 {
+		return 0, errors.New("The number of retries must be a non-negative " +
+			"number!")
+	}
+	return retries, nil
+}
+
+// Parses the bounds of the tasks' timeouts as configured by the
+// TASK_TIMEOUT_MIN and TASK_TIMEOUT_MAX variables.
+func parseTimeoutBounds() error {
+	min_task_timeout, max_task_timeout = default_min_timeout,
+		default_max_timeout
+	if task_timeout_min != "" {
+		value, err := strconv.ParseInt(task_timeout_min, 10, 64)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("%s must be a positive number!",
+				task_timeout_min_var)
+		}
+		min_task_timeout = value
+	}
+	if task_timeout_max != "" {
+		value, err := strconv.ParseInt(task_timeout_max, 10, 64)
+		if err != nil || value <= 0 {
+			return fmt.Errorf("%s must be a positive number!",
+				task_timeout_max_var)
+		}
+		max_task_timeout = value
+	}
+	if min_task_timeout > max_task_timeout {
+		return fmt.Errorf("%s must not exceed %s!", task_timeout_min_var,
+			task_timeout_max_var)
+	}
+	return nil
+}
+
+// Returns the default timeout of a Bot kept within the bounds.
+func defaultTimeout() int64 {
+	timeout := int64(default_timeout)
+	if timeout < min_task_timeout {
+		timeout = min_task_timeout
+	}
+	if timeout > max_task_timeout {
+		timeout = max_task_timeout
+	}
+	return timeout
+}
+
+// Parses the timeout of a Bot in seconds. If no value is given the default
+// timeout is used.
+func parseTimeout(value string) (int64, error) {
+	if value == "" {
+		return defaultTimeout(), nil
+	}
+	timeout, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || timeout < min_task_timeout ||
+		timeout > max_task_timeout {
+		return 0, fmt.Errorf("The timeout must be a number between %d and "+
+			"%d seconds!", min_task_timeout, max_task_timeout)
+	}
+	return timeout, nil
+}
+
+// Reads the routing rule submitted via `r`, i.e. the policy `routing` and the
+// ids of the workers on the allow-list `allowed_workers`. An empty policy
+// yields nil, i.e. no rule of its own.
+func parseRoutingRule(r *http.Request) (*db.RoutingRule, error) {
+	value := r.FormValue("routing")
+	if value == "" {
+		return nil, nil
+	}
+	policy, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, errors.New("Unknown routing policy!")
+	}
+
+	rule := &db.RoutingRule{Policy: policy}
+	allowed := make(map[int64]bool)
+	for _, value := range r.Form["allowed_workers"] {
+		wid, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return nil, errors.New("The workers must be given by their ids!")
+		}
+		if !allowed[wid] {
+			allowed[wid] = true
+			rule.Workers = append(rule.Workers, wid)
+		}
+	}
+	return rule, nil
+}
+
+// Returns the time zone a schedule is evaluated in. Schedules without a time
+// zone use UTC.
+func scheduleTimezone(value string) string {
+	if value == "" {
+		return "UTC"
+	}
+	return value
+}
+
+// Reads the misfire policy `misfire` of a schedule and the maximal number of
+// missed executions that are caught up `misfire_limit` submitted via `r`.
+// Values that are left empty fall back to `misfire` and `limit`.
+func parseMisfirePolicy(r *http.Request, misfire, limit int64) (int64, int64,
+	error) {
+	if value := r.FormValue("misfire"); value != "" {
+		var err error
+		misfire, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || misfire < db.Misfire_skip ||
+			misfire > db.Misfire_run_all {
+			return 0, 0, errors.New("Unknown misfire policy!")
+		}
+	}
+	if value := r.FormValue("misfire_limit"); value != "" {
+		var err error
+		limit, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || limit < 1 || limit > db.Max_misfire_limit {
+			return 0, 0, fmt.Errorf("At most %d missed executions can be "+
+				"caught up!", db.Max_misfire_limit)
+		}
+	}
+	return misfire, limit, nil
+}
+
+// Applies the changes to the definition of the user's scheduled, one time or
+// event task `tid` submitted via `r` and returns the edited task group. The
+// arguments are the ones used for creating the task groups (see
+// `handleTasksNewScheduled` and `handleTasksNewOneTime`), 'event' is the event
+// of an event task and 'bid' the id of the new bot. Values that are left empty
+// are not changed. The scheduling go routine of an active task is restarted
+// and the hook of an event task is updated on GitHub after the task was stored.
+// If the hook cannot be updated the changes are rolled back and
+// `hookUpdateFailure` is returned. `unknownTaskGroup` is returned if the user
+// has no such task, any other error is caused by invalid input.
+func editTaskGroup(r *http.Request, tid, token string) (interface{}, error) {
+	group, err := db.GetTaskGroup(tid, token)
+	if err != nil {
+		return nil, unknownTaskGroup
+	}
+
+	// bot and name are common to all task groups
+	var bot *db.Bot
+	switch task := group.(type) {
+	case *db.ScheduledTask:
+		bot = task.Bot
+	case *db.OneTimeTask:
+		bot = task.Bot
+	case *db.EventTask:
+		bot = task.Bot
+	default:
+		return nil, errors.New("Only scheduled, one time and event " +
+			"triggered tasks can be edited!")
+	}
+	if value := r.FormValue("bid"); value != "" {
+		if bot, err = db.GetBot(value); err != nil {
+			return nil, errors.New("Unknown bot!")
+		}
+	}
+	name := r.FormValue("name")
+
+	switch task := group.(type) {
+	case *db.ScheduledTask:
+		if name == "" {
+			name = task.Name
+		}
+		cron_str, timezone := task.Cron, task.Timezone
+		if value := r.FormValue("cron"); value != "" {
+			cron_str = strings.Replace(value, "_", " ", -1)
+		}
+		if value := r.FormValue("timezone"); value != "" {
+			timezone = value
+		}
+		nextTimes, err := worker.NextScheduleTimes(cron_str, timezone,
+			time.Now(), 1)
+		if err != nil {
+			return nil, err
+		}
+		misfire, misfire_limit, err := parseMisfirePolicy(r, task.Misfire,
+			task.Misfire_limit)
+		if err != nil {
+			return nil, err
+		}
+
+		edited, err := db.UpdateScheduledTask(task.Id, bot.Id, name,
+			nextTimes[0], cron_str, timezone, misfire, misfire_limit)
+		if err != nil {
+			return nil, err
+		}
+		if edited.IsActive() {
+			worker.RestartScheduledTask(edited.Id)
+		}
+		return edited, nil
+	case *db.OneTimeTask:
+		if name == "" {
+			name = task.Name
+		}
+		exec_time := task.Exec_time
+		if value := r.FormValue("time"); value != "" {
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			exec_time = time.Unix(seconds/1000, 0)
+		}
+
+		edited, err := db.UpdateOneTimeTask(task.Id, bot.Id, name, exec_time)
+		if err != nil {
+			return nil, err
+		}
+		if edited.IsActive() {
+			worker.RestartOneTimeTask(edited.Id)
+		}
+		return edited, nil
+	case *db.EventTask:
+		if name == "" {
+			name = task.Name
+		}
+		event := task.Event
+		if value := r.FormValue("event"); value != "" {
+			event, err = strconv.ParseInt(value, 10, 64)
+			if err != nil || event < 0 ||
+				event >= int64(len(db.Event_names)) {
+				return nil, errors.New("Unknown event!")
+			}
+		}
+
+		edited, err := db.UpdateEventTask(task.Id, bot.Id, name, event)
+		if err != nil {
+			return nil, err
+		}
+
+		// the hook has to deliver the new event (see
+		// https://developer.github.com/v3/repos/hooks/)
+		if event != task.Event && task.HookId != 0 {
+			url := fmt.Sprintf("repos/%s/hooks/%d", task.Project.Name,
+				task.HookId)
+			payload := make(map[string]interface{})
+			payload["events"] = [...]string{edited.EventString()}
+			if _, err := authGitHubRequest("PATCH", url, token, payload,
+				make(map[string]string), http.StatusOK); err != nil {
+				fmt.Println(err)
+				// the hook still delivers the previous event
+				db.UpdateEventTask(task.Id, task.Bot.Id, task.Name,
+					task.Event)
+				return nil, hookUpdateFailure
+			}
+		}
+
+		return edited, nil
+	}
+
+	return nil, errors.New("Ups! This should not happen!")
+}
+
+// Parses the id of the worker the task lists are filtered by. An empty value
+// yields 0, i.e. the task lists are not filtered.
+func parseWorkerFilter(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+	wid, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || wid <= 0 {
+		return 0, errors.New("The worker must be given by its id!")
+	}
+	return wid, nil
+}
+
+// Parses the number of the requested page of the queue. An empty value yields
+// the first page.
+func parseQueuePage(value string) (int64, error) {
+	if value == "" {
+		return 1, nil
+	}
+	page, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || page <= 0 {
+		return 0, errors.New("The page must be a positive number!")
+	}
+	return page, nil
+}
+
+// Helper to render the given page of the queue using the template "queue".
+// `All` tells whether the queue of all users is shown.
+func renderQueue(w http.ResponseWriter, queue *db.Queue, page int64,
+	all bool) {
+	data := make(map[string]interface{})
+	data["Queue"] = queue
+	data["All"] = all
+	data["Page"] = page
+	if page > 1 {
+		data["Previous_page"] = page - 1
+	}
+	if page*queue_page_size < queue.Total {
+		data["Next_page"] = page + 1
+	}
+	data["Subdir"] = application_subdirectory
+	renderTemplate(w, "queue", data)
+}
+
+// Returns the value of the form field `name` submitted via `r` and whether the
+// field was submitted at all.
+func lookupFormValue(r *http.Request, name string) (string, bool) {
+	value := r.FormValue(name)
+	_, ok := r.Form[name]
+	return value, ok
+}
+
+// Reads the task group settings submitted via `r` into `settings`. Only the
+// fields that were submitted are changed, thus the API allows updating single
+// settings. Fields that are left empty fall back to the defaults of the Bot
+// or, in case of the routing rule, to the rule of the project.
+func parseTaskGroupSettings(r *http.Request,
+	settings *db.TaskGroupSettings) error {
+	if value, ok := lookupFormValue(r, "max_retries"); ok {
+		settings.Max_retries = nil
+		if value != "" {
+			retries, err := parseRetries(value)
+			if err != nil {
+				return err
+			}
+			settings.Max_retries = &retries
+		}
+	}
+	if value, ok := lookupFormValue(r, "timeout"); ok {
+		settings.Timeout = nil
+		if value != "" {
+			timeout, err := parseTimeout(value)
+			if err != nil {
+				return err
+			}
+			settings.Timeout = &timeout
+		}
+	}
+	if value, ok := lookupFormValue(r, "priority"); ok {
+		settings.Priority = 0
+		if value != "" {
+			priority, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("The priority must be a number!")
+			}
+			settings.Priority = priority
+		}
+	}
+	if _, ok := lookupFormValue(r, "routing"); ok {
+		routing, err := parseRoutingRule(r)
+		if err != nil {
+			return err
+		}
+		settings.Routing = routing
+	}
+	if value, ok := lookupFormValue(r, "overlap"); ok {
+		settings.Overlap = db.Overlap_allow
+		if value != "" {
+			overlap, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return errors.New("Unknown overlap policy!")
+			}
+			settings.Overlap = overlap
+		}
+	}
+	return nil
+}
+
+// Writes a Server-Sent Event of the given type and id. Every line of `data` is
+// sent as a separate data field such that the client receives `data`
+// unchanged.
+func writeServerSentEvent(w http.ResponseWriter, event string, id int64,
+	data string) {
+	fmt.Fprintf(w, "event: %s\nid: %d\n", event, id)
+	data = strings.Replace(data, "\r\n", "\n", -1)
+	data = strings.Replace(data, "\r", "\n", -1)
+	for _, line := range strings.Split(data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", line)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// Streams the output of the task as Server-Sent Events until the task finished
+// or the client disconnected. Every chunk of the output is sent as an event of
+// type "stdout" or "stderr" whose id is the chunk's sequence number. A client
+// that reconnects passes the id of the last event it received via the
+// "Last-Event-ID" header (or the `after` parameter) and only receives newer
+// chunks. After the task finished an event of type "end" carrying the task's
+// status is sent.
+func streamTaskOutput(w http.ResponseWriter, r *http.Request, task *db.Task) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported!",
+			http.StatusInternalServerError)
+		return
+	}
+
+	after := int64(-1)
+	last_id := r.Header.Get("Last-Event-ID")
+	if last_id == "" {
+		last_id = r.FormValue("after")
+	}
+	if seq, err := strconv.ParseInt(last_id, 10, 64); err == nil {
+		after = seq
+	}
+
+	subscription := worker.SubscribeTaskOutput(task.Id)
+	defer worker.UnsubscribeTaskOutput(task.Id, subscription)
+	keepalive := time.NewTicker(output_keepalive_interval * time.Second)
+	defer keepalive.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	for {
+		// the status is checked before the output is fetched such that no
+		// output of a task that finished in the meantime is missed
+		current, err := db.GetTaskById(task.Id)
+		if err != nil {
+			return
+		}
+		chunks, err := db.GetTaskOutput(task.Id, after)
+		if err != nil {
+			return
+		}
+		for _, chunk := range chunks {
+			writeServerSentEvent(w, chunk.Stream, chunk.Seq, chunk.Content)
+			after = chunk.Seq
+		}
+		if !current.IsPending() && !current.IsScheduled() &&
+			!current.IsRunning() {
+			fmt.Fprintf(w, "event: end\ndata: %s\n\n", current.StatusString())
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-subscription:
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// Sends the content of the artifact as file attachment. The attachment is never
+// displayed inline in order to prevent the browser from executing HTML or
+// JavaScript content uploaded by a bot.
+func sendArtifact(w http.ResponseWriter, artifact *db.Artifact) {
+	file, err := worker.OpenArtifact(artifact)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	defer file.Close()
+
+	w.Header().Set("Content-Type", artifact.Content_type)
+	w.Header().Set("Content-Length", strconv.FormatInt(artifact.Size, 10))
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment",
+		map[string]string{"filename": artifact.Name}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, file)
+}
+
 //
 // Route handler
 //
@@ -934,26 +1683,324 @@ func handleUserRevokeAPIToken(w http.ResponseWriter, r *http.Request,
 		http.StatusFound)
 }
 
-// The handler invalidates the specified worker for the user and redirects to
-// the user page.
-func handleUserDegegisterWorker(w http.ResponseWriter, r *http.Request,
+// The handler displays the details of the worker identified by its id, i.e.
+// the statistics of its executions, the tasks it currently executes and its
+// latest executions and connections. Users can view their own workers, admins
+// all shared workers. If an error occurs the `handleError` function is called
+// else `renderTemplate` with the template "user-worker" and the retrieved
+// data.
+func handleUserWorker(w http.ResponseWriter, r *http.Request,
 	vars map[string]string, session *sessions.Session, token string) {
-	worker_token := r.FormValue("token")
-	if worker_token == "" {
-		handleError(w, r, errors.New("No Worker token specified!"))
+	user, err := db.GetUser(token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	shown, err := db.GetWorkerById(vars["wid"])
+	if err != nil || (shown.Uid != user.Id && !(shown.Shared && user.Admin)) {
+		handleError(w, r, errors.New("The worker does not exist!"))
+		return
+	}
+
+	stats, err := db.GetWorkerStatistics(shown.Id)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	current, err := db.GetWorkerExecutions(shown.Id, true,
+		worker_history_size)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	executions, err := db.GetWorkerExecutions(shown.Id, false,
+		worker_history_size)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	connections, err := db.GetWorkerConnections(shown.Id,
+		worker_history_size)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["Worker"] = shown
+	data["Statistics"] = stats
+	data["Current_executions"] = current
+	data["Executions"] = executions
+	data["Connections"] = connections
+	data["History_size"] = worker_history_size
+	data["Subdir"] = application_subdirectory
+	renderTemplate(w, "user-worker", data)
+}
+
+// The handler displays the shared workers of all users along with the
+// statistics of their executions. Only admins can view this page. If an error
+// occurs the `handleError` function is called else `renderTemplate` with the
+// template "admin-workers" and the retrieved data.
+func handleAdminWorkers(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	user, err := db.GetUser(token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if !user.Admin {
+		handleError(w, r, errors.New("Only admins can view shared workers!"))
+		return
+	}
+
+	summaries, err := db.GetSharedWorkerSummaries()
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["Summaries"] = summaries
+	data["Subdir"] = application_subdirectory
+	renderTemplate(w, "admin-workers", data)
+}
+
+// The handler requests the queue entries of the pending tasks of all users,
+// one page (given by the parameter "page") at a time. Only admins can view
+// them. If an error occurs the `handleError` function is called else
+// `renderQueue` with the retrieved data.
+func handleAdminQueue(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	user, err := db.GetUser(token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if !user.Admin {
+		handleError(w, r, errors.New("Only admins can view the whole queue!"))
+		return
+	}
+
+	page, err := parseQueuePage(r.FormValue("page"))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	queue, err := db.GetQueue("", (page-1)*queue_page_size, queue_page_size)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	renderQueue(w, queue, page, true)
+}
+
+// The handler displays all users along with the weight of their share of the
+// shared workers. Only admins can view this page. If an error occurs the
+// `handleError` function is called else `renderTemplate` with the template
+// "admin-users" and the retrieved data.
+func handleAdminUsers(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	user, err := db.GetUser(token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if !user.Admin {
+		handleError(w, r, errors.New("Only admins can view all users!"))
 		return
 	}
 
+	users, err := db.GetUsers()
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["Users"] = users
+	data["Subdir"] = application_subdirectory
+	renderTemplate(w, "admin-users", data)
+}
+
+// The handler sets the weight of the share of the shared workers of the user
+// specified by the "uid" parameter to the "share_weight" parameter. Only
+// admins can change the weights. In the end the admin is redirected to the
+// overview of the users. In case of an error the errorhandler is called.
+func handleAdminUsersPost(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	user, err := db.GetUser(token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if !user.Admin {
+		handleError(w, r, errors.New("Only admins can change share weights!"))
+		return
+	}
+
+	weight, err := strconv.ParseInt(r.FormValue("share_weight"), 10, 64)
+	if err != nil {
+		handleError(w, r, errors.New("The share weight must be a number!"))
+		return
+	}
+	if err := db.SetUserShareWeight(r.FormValue("uid"), weight); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%sadmin/users", application_subdirectory),
+		http.StatusFound)
+}
+
+// The handler invalidates the specified worker for the user and redirects to
+// the user page. If the "drain" parameter is set the worker finishes its current
+// task before it is invalidated.
+func handleUserDegegisterWorker(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	worker_token := r.FormValue("token")
+	if worker_token == "" {
+		handleError(w, r, errors.New("No Worker token specified!"))
+		return
+	}
+
+	if drain, _ := strconv.ParseBool(r.FormValue("drain")); drain {
+		if _, err := drainWorker(token, worker_token, true, true); err != nil {
+			handleError(w, r, err)
+			return
+		}
+		http.Redirect(w, r, fmt.Sprintf("%suser", application_subdirectory),
+			http.StatusFound)
+		return
+	}
+
+	// the tasks of the worker cannot be finished any longer
+	tids, err := db.GetWorkerTasks(token, worker_token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
 	worker.DeleteWorker(worker_token)
 	if err := db.DeleteWorker(token, worker_token); err != nil {
 		handleError(w, r, err)
 		return
 	}
+	actor := actorName(token)
+	for _, tid := range tids {
+		worker.Cancel(tid, db.Canceled_by_deregistered, actor)
+	}
 
 	http.Redirect(w, r, fmt.Sprintf("%suser", application_subdirectory),
 		http.StatusFound)
 }
 
+// The handler sets (or clears if the "drain" parameter is false) the draining
+// state of the specified worker and redirects to the user page.
+func handleUserDrainWorker(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	worker_token := r.FormValue("token")
+	if worker_token == "" {
+		handleError(w, r, errors.New("No Worker token specified!"))
+		return
+	}
+	draining, err := strconv.ParseBool(r.FormValue("drain"))
+	if err != nil {
+		handleError(w, r, errors.New("Invalid drain parameter!"))
+		return
+	}
+
+	if _, err := drainWorker(token, worker_token, draining,
+		false); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%suser", application_subdirectory),
+		http.StatusFound)
+}
+
+// The handler removes the binding of the specified worker to its client
+// certificate and redirects to the user page.
+func handleUserResetWorkerCertificate(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	worker_token := r.FormValue("token")
+	if worker_token == "" {
+		handleError(w, r, errors.New("No Worker token specified!"))
+		return
+	}
+
+	if err := db.ResetWorkerCertificate(token, worker_token); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%suser", application_subdirectory),
+		http.StatusFound)
+}
+
+// The handler forwards the requests of Git's smart HTTP protocol for cloning the
+// project of a task to GitHub. Workers authenticate using the task's clone
+// token (passed as password via HTTP basic authentication) such that they never
+// see the user's OAuth token. Only fetching is supported.
+func handleCloneProxy(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	tid, _ := strconv.ParseInt(vars["tid"], 10, 64)
+	_, clone_token, ok := r.BasicAuth()
+	var task *db.Task
+	var err error
+	if ok {
+		task, err = db.GetCloneTask(tid, clone_token)
+	}
+	if !ok || err != nil {
+		w.Header().Set("WWW-Authenticate",
+			"Basic realm=\"Analysis Bots Platform\"")
+		http.Error(w, "Invalid clone token!", http.StatusUnauthorized)
+		return
+	}
+	if vars["service"] == "info/refs" &&
+		r.URL.Query().Get("service") != "git-upload-pack" {
+		http.Error(w, "Only fetching is supported!", http.StatusForbidden)
+		return
+	}
+
+	github_url := fmt.Sprintf("https://github.com/%s.git/%s",
+		task.Project.Name, vars["service"])
+	if r.URL.RawQuery != "" {
+		github_url += "?" + r.URL.RawQuery
+	}
+	req, err := http.NewRequest(r.Method, github_url, r.Body)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	req.ContentLength = r.ContentLength
+	for _, header := range clone_request_headers {
+		if value := r.Header.Get(header); value != "" {
+			req.Header.Set(header, value)
+		}
+	}
+	req.SetBasicAuth(task.User.Token, "x-oauth-basic")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for _, header := range clone_response_headers {
+		if value := resp.Header.Get(header); value != "" {
+			w.Header().Set(header, value)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
 // The handler verifies that the logged in user has access to the requested
 // patch file and if he has the file content is sent back.
 func handlePatchDownload(w http.ResponseWriter, r *http.Request,
@@ -962,16 +2009,28 @@ func handlePatchDownload(w http.ResponseWriter, r *http.Request,
 	if err != nil {
 		http.Error(w, fmt.Sprint(err), http.StatusNotFound)
 	} else {
-		file_path := fmt.Sprintf("%s/%s", worker.GetPatchPath(), file_name)
-		in, err := ioutil.ReadFile(file_path)
+		patch, err := worker.OpenPatch(file_name)
 		if err != nil {
 			handleError(w, r, err)
 		} else {
-			w.Write(in)
+			defer patch.Close()
+			io.Copy(w, patch)
 		}
 	}
 }
 
+// The handler verifies that the logged in user has access to the task the
+// requested artifact belongs to and if he has the artifact is sent back.
+func handleArtifactDownload(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	artifact, err := db.GetArtifact(token, vars["tid"], vars["aid"])
+	if err != nil {
+		http.Error(w, fmt.Sprint(err), http.StatusNotFound)
+	} else {
+		sendArtifact(w, artifact)
+	}
+}
+
 // The handler applies the Git patch to the project if applicable. This involves
 // the following steps:
 // - Verify that the user is allowed to perform this action.
@@ -1066,6 +2125,9 @@ func handleBots(w http.ResponseWriter, r *http.Request,
 func handleBotsNewForm(w http.ResponseWriter, r *http.Request,
 	vars map[string]string, session *sessions.Session, token string) {
 	data := make(map[string]interface{})
+	data["Default_timeout"] = defaultTimeout()
+	data["Min_timeout"] = min_task_timeout
+	data["Max_timeout"] = max_task_timeout
 	data["Subdir"] = application_subdirectory
 	renderTemplate(w, "bots-new", data)
 }
@@ -1085,6 +2147,16 @@ func handleBotsNewPost(w http.ResponseWriter, r *http.Request,
 		handleError(w, r, errors.New("Not all input fields were filled in!"))
 		return
 	}
+	max_retries, err := parseRetries(r.FormValue("max_retries"))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	timeout, err := parseTimeout(r.FormValue("timeout"))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
 	resp, err := http.Get(
 		fmt.Sprintf("https://index.docker.io/v1/repositories/%s/tags", path))
 	if err != nil {
@@ -1096,7 +2168,8 @@ func handleBotsNewPost(w http.ResponseWriter, r *http.Request,
 		handleError(w, r, errors.New("Docker Hub entry does not exist!"))
 		return
 	}
-	if _, err := db.AddBot(path, description, tags); err != nil {
+	if _, err := db.AddBot(path, description, tags,
+		r.FormValue("requirements"), max_retries, timeout); err != nil {
 		handleError(w, r, err)
 		return
 	}
@@ -1189,12 +2262,47 @@ func handleProjectsPid(w http.ResponseWriter, r *http.Request,
 	project, err := db.GetProject(vars["pid"], token)
 	if err != nil {
 		handleError(w, r, err)
-	} else {
-		data := make(map[string]interface{})
-		data["Project"] = project
-		data["Subdir"] = application_subdirectory
-		renderTemplate(w, "projects-pid", data)
+		return
+	}
+	routing, err := db.GetProjectRouting(vars["pid"], token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	workers, err := db.GetUsableWorkers(token)
+	if err != nil {
+		handleError(w, r, err)
+		return
 	}
+
+	data := make(map[string]interface{})
+	data["Project"] = project
+	data["Routing"] = routing
+	data["Workers"] = workers
+	data["Subdir"] = application_subdirectory
+	renderTemplate(w, "projects-pid", data)
+}
+
+// The handler stores the submitted routing rule of the project and redirects
+// to the project's page. In case of an error the errorhandler is called.
+func handleProjectsPidRouting(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	routing, err := parseRoutingRule(r)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if routing == nil {
+		routing = &db.RoutingRule{Policy: db.Routing_shared_allowed}
+	}
+	if err := db.UpdateProjectRouting(vars["pid"], token,
+		routing); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%sprojects/%s", application_subdirectory,
+		vars["pid"]), http.StatusFound)
 }
 
 // The handler requests detailed information about the project identified by its
@@ -1223,9 +2331,10 @@ func handleProjectsPidNewtask(w http.ResponseWriter, r *http.Request,
 	}
 }
 
-// The handler requests information about all tasks ran by the user. If an error
-// occurs the `handleError` function is called else `renderTemplate` with the
-// template "tasks" and the retrieved data.
+// The handler requests information about all tasks ran by the user. The tasks
+// can be restricted to those executed by a worker (see `parseWorkerFilter`). If
+// an error occurs the `handleError` function is called else `renderTemplate`
+// with the template "tasks" and the retrieved data.
 func handleTasks(w http.ResponseWriter, r *http.Request,
 	vars map[string]string, session *sessions.Session, token string) {
 	err := updateHooks(token)
@@ -1234,22 +2343,28 @@ func handleTasks(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
-	scheduled, err := db.GetScheduledTasks(token)
+	wid, err := parseWorkerFilter(r.FormValue("worker"))
 	if err != nil {
 		handleError(w, r, err)
 		return
 	}
-	event, err := db.GetEventTasks(token)
+
+	scheduled, err := db.GetScheduledTasks(token, wid)
 	if err != nil {
 		handleError(w, r, err)
 		return
 	}
-	instant, err := db.GetInstantTasks(token)
+	event, err := db.GetEventTasks(token, wid)
 	if err != nil {
 		handleError(w, r, err)
 		return
 	}
-	one_time, err := db.GetOneTimeTasks(token)
+	instant, err := db.GetInstantTasks(token, wid)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	one_time, err := db.GetOneTimeTasks(token, wid)
 	if err != nil {
 		handleError(w, r, err)
 		return
@@ -1261,8 +2376,21 @@ func handleTasks(w http.ResponseWriter, r *http.Request,
 	task_groups["Instant"] = instant
 	task_groups["OneTime"] = one_time
 
+	// preview of the upcoming executions of the active schedules
+	upcoming := make(map[int64][]time.Time)
+	for _, scheduled_task := range scheduled {
+		if !scheduled_task.Task.IsActive() {
+			continue
+		}
+		upcoming[scheduled_task.Task.Id], _ = worker.NextScheduleTimes(
+			scheduled_task.Task.Cron, scheduled_task.Task.Timezone,
+			time.Now(), worker.Schedule_preview_size)
+	}
+
 	data := make(map[string]interface{})
 	data["TaskGroups"] = task_groups
+	data["Upcoming"] = upcoming
+	data["Worker"] = wid
 	data["Subdir"] = application_subdirectory
 	renderTemplate(w, "tasks", data)
 }
@@ -1276,17 +2404,116 @@ func handleTasksTid(w http.ResponseWriter, r *http.Request,
 	if err != nil {
 		handleError(w, r, err)
 	} else {
-		task.Output = template.HTMLEscapeString(task.Output)
-		task.Output = strings.Replace(task.Output, "\n", "<br>", -1)
-		output := template.HTML(task.Output)
+		attempts, err := db.GetTaskAttempts(task)
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		artifacts, err := db.GetArtifacts(token, vars["tid"])
+		if err != nil {
+			handleError(w, r, err)
+			return
+		}
+		var queue_entry *db.Queue_entry
+		if task.IsPending() {
+			if queue_entry, err = db.GetQueueEntry(task.Id); err != nil {
+				handleError(w, r, err)
+				return
+			}
+		}
 		data := make(map[string]interface{})
 		data["Task"] = task
-		data["Output"] = output
+		data["Artifacts"] = artifacts
+		data["Attempts"] = attempts
+		data["Queue_entry"] = queue_entry
 		data["Subdir"] = application_subdirectory
 		renderTemplate(w, "tasks-tid", data)
 	}
 }
 
+// The handler streams the output of the task identified by its id (see
+// `streamTaskOutput`). If the task does not exist an error is sent.
+func handleTasksTidOutput(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	task, err := db.GetTask(vars["tid"], token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	streamTaskOutput(w, r, task)
+}
+
+// The handler displays the execution settings of the task group identified by
+// its id. If an error occurs the `handleError` function is called else
+// `renderTemplate` with the template "tasks-tid-settings" and the retrieved
+// data.
+func handleTasksTidSettingsForm(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	settings, err := db.GetTaskGroupSettings(vars["tid"], token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	workers, err := db.GetUsableWorkers(token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["Settings"] = settings
+	data["Workers"] = workers
+	data["Max_priority_adjustment"] = db.Max_priority_adjustment
+	data["Min_timeout"] = min_task_timeout
+	data["Max_timeout"] = max_task_timeout
+	data["Subdir"] = application_subdirectory
+	renderTemplate(w, "tasks-tid-settings", data)
+}
+
+// The handler requests the queue entries of the user's pending tasks, one page
+// (given by the parameter "page") at a time. If an error occurs the
+// `handleError` function is called else `renderQueue` with the retrieved data.
+func handleTasksQueue(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	page, err := parseQueuePage(r.FormValue("page"))
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	queue, err := db.GetQueue(token, (page-1)*queue_page_size,
+		queue_page_size)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	renderQueue(w, queue, page, false)
+}
+
+// The handler stores the submitted execution settings of the task group and
+// redirects to the overview page of the tasks. In case of an error the
+// errorhandler is called.
+func handleTasksTidSettingsPost(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	settings, err := db.GetTaskGroupSettings(vars["tid"], token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if err := parseTaskGroupSettings(r, settings); err != nil {
+		handleError(w, r, err)
+		return
+	}
+	if err := db.UpdateTaskGroupSettings(token, settings); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%stasks/", application_subdirectory),
+		http.StatusFound)
+}
+
 // The handler creates a new event triggered task by using the query arguments
 // 'name' and 'event'. After creating a new event task instance a new web hook
 // on GitHub is created. (How this is done you can lookup here:
@@ -1323,12 +2550,8 @@ func handleTasksNewEventDriven(w http.ResponseWriter, r *http.Request,
 	payload["active"] = true
 	payload["events"] = [...]string{task.EventString()}
 	config := make(map[string]interface{})
-	ssl := ""
-	if is_ssl, _ := strconv.ParseBool(application_ssl_mode); is_ssl {
-		ssl = "s"
-	}
-	config["url"] = fmt.Sprintf("http%s://%s%s%s/%d", ssl, application_host,
-		application_subdirectory, webhook_subpath, task.Id)
+	config["url"] = fmt.Sprintf("%s%s/%d", applicationURL(), webhook_subpath,
+		task.Id)
 	config["content_type"] = "json"
 	config["secret"] = task.Token
 	payload["config"] = config
@@ -1356,8 +2579,11 @@ func handleTasksNewEventDriven(w http.ResponseWriter, r *http.Request,
 }
 
 // The handler creates a new scheduled task by using the query arguments
-// 'name' and 'cron'. The 'cron' argument is a a unix cron expression
-// (https://en.wikipedia.org/wiki/Cron) to identify the schedule times. First
+// 'name', 'cron' and 'timezone'. The 'cron' argument is a a unix cron
+// expression (https://en.wikipedia.org/wiki/Cron) to identify the schedule
+// times. It is evaluated in the IANA time zone 'timezone' (default: UTC).
+// 'misfire' and 'misfire_limit' determine how executions missed while the
+// platform was down are handled (see `parseMisfirePolicy`). First
 // the next time satisfying the cron expression is calculated (corresponds to
 // the next execution time). Then a a new instance of scheduled task is created
 // and a go routine for scheduling the the task is started. In the end the
@@ -1367,16 +2593,23 @@ func handleTasksNewScheduled(w http.ResponseWriter, r *http.Request,
 	vars map[string]string, session *sessions.Session, token string) {
 
 	cron_str := strings.Replace(r.FormValue("cron"), "_", " ", -1)
-	nextTime := cronexpr.MustParse(cron_str).Next(time.Now())
-	if nextTime.IsZero() {
-		handleError(w, r,
-			fmt.Errorf("The cron expression <%s> could not have been parsed.",
-				cron_str))
+	timezone := scheduleTimezone(r.FormValue("timezone"))
+	nextTimes, err := worker.NextScheduleTimes(cron_str, timezone, time.Now(),
+		1)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	// missed executions are skipped by default
+	misfire, misfire_limit, err := parseMisfirePolicy(r, db.Misfire_skip, 1)
+	if err != nil {
+		handleError(w, r, err)
 		return
 	}
 
 	scheduledTask, err := db.CreateScheduledTask(token, vars["pid"],
-		vars["bid"], r.FormValue("name"), nextTime, cron_str)
+		vars["bid"], r.FormValue("name"), nextTimes[0], cron_str, timezone,
+		misfire, misfire_limit)
 	if err != nil {
 		handleError(w, r, err)
 		return
@@ -1387,6 +2620,29 @@ func handleTasksNewScheduled(w http.ResponseWriter, r *http.Request,
 		http.StatusFound)
 }
 
+// The handler sends the next execution times of the schedule given by the
+// query arguments 'cron' and 'timezone' (see `handleTasksNewScheduled`) as
+// JSON array. If the schedule is invalid an error is sent.
+func handleTasksSchedulePreview(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	cron_str := strings.Replace(r.FormValue("cron"), "_", " ", -1)
+	nextTimes, err := worker.NextScheduleTimes(cron_str,
+		scheduleTimezone(r.FormValue("timezone")), time.Now(),
+		worker.Schedule_preview_size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	js, err := json.Marshal(nextTimes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
+
 // The handler creates a new one time task by using the query arguments 'name'
 // and 'time'. The 'time' argument is passed in unix time and is the time stamp
 // the task should be executed. If it is the past the task is executed
@@ -1441,7 +2697,7 @@ func handleTasksNewInstant(w http.ResponseWriter, r *http.Request,
 // during the creation of a hook. The url '.../webhook/id' ends with the id
 // of the associated event task to identify the request. After checking the
 // validity of the request CreateNewTask is called to initiate the execution of
-// the event task.
+// the event task unless the event task is not active (e.g. paused).
 func handleWebhook(w http.ResponseWriter, r *http.Request) {
 
 	if r.Header.Get("X-GitHub-Event") == "ping" {
@@ -1472,16 +2728,27 @@ func handleWebhook(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// deliveries for paused or completed tasks are ignored
+	eventTask, err := db.GetEventTask(tid)
+	if err != nil || !eventTask.IsActive() {
+		return
+	}
+
 	worker.CreateNewTask(tid)
 }
 
-// The handler attempts to cancel the specified task. If this fails the
-// `handleError` function is called else the user is redirected to the Bot
+// The handler attempts to cancel the specified task of the user. If this fails
+// the `handleError` function is called else the user is redirected to the Bot
 // status overview page.
 func handleTasksTidCancel(w http.ResponseWriter, r *http.Request,
 	vars map[string]string, session *sessions.Session, token string) {
-	tid, _ := strconv.ParseInt(vars["tid"], 10, 64)
-	worker.Cancel(tid)
+	task, err := db.GetTask(vars["tid"], token)
+	if err != nil {
+		handleError(w, r, errors.New(
+			"The task id was not known and thus could not have been canceled."))
+		return
+	}
+	worker.Cancel(task.Id, db.Canceled_by_user, actorName(token))
 
 	http.Redirect(w, r, fmt.Sprintf("%stasks/", application_subdirectory),
 		http.StatusFound)
@@ -1502,12 +2769,13 @@ func handleTasksTidCancelGroup(w http.ResponseWriter, r *http.Request,
 		return
 	}
 
+	actor := actorName(token)
 	switch task.(type) {
 	case *db.ScheduledTask:
-		err = worker.CancelScheduledTask(task.(*db.ScheduledTask).Id)
+		err = worker.CancelScheduledTask(task.(*db.ScheduledTask).Id, actor)
 	case *db.EventTask:
 		eventTask := task.(*db.EventTask)
-		err = worker.CancelEventTask(eventTask.Id)
+		err = worker.CancelEventTask(eventTask.Id, actor)
 		url := fmt.Sprintf("repos/%s/hooks/%d", eventTask.Project.Name,
 			eventTask.HookId)
 		if _, err := authGitHubRequest("DELETE", url, token,
@@ -1517,15 +2785,114 @@ func handleTasksTidCancelGroup(w http.ResponseWriter, r *http.Request,
 			return
 		}
 	case *db.OneTimeTask:
-		err = worker.CancelOneTimeTask(task.(*db.OneTimeTask).Id)
+		err = worker.CancelOneTimeTask(task.(*db.OneTimeTask).Id, actor)
 	case *db.InstantTask:
-		err = worker.CancelInstantTask(task.(*db.InstantTask).Id)
+		err = worker.CancelInstantTask(task.(*db.InstantTask).Id, actor)
+	}
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%stasks/", application_subdirectory),
+		http.StatusFound)
+}
+
+// The handler shows the form for editing the definition of a scheduled, one
+// time or event task. In case of an error the errorhandler is called.
+func handleTasksTidEditForm(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	group, err := db.GetTaskGroup(vars["tid"], token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+	bots, err := db.GetBots()
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	switch group.(type) {
+	case *db.ScheduledTask:
+		data["Kind"] = "Scheduled"
+	case *db.OneTimeTask:
+		data["Kind"] = "OneTime"
+	case *db.EventTask:
+		data["Kind"] = "Event"
+	default:
+		handleError(w, r, errors.New("Only scheduled, one time and event "+
+			"triggered tasks can be edited!"))
+		return
+	}
+	data["Group"] = group
+	data["Bots"] = bots
+	data["Event_names"] = db.Event_names
+	data["Max_misfire_limit"] = db.Max_misfire_limit
+	data["Subdir"] = application_subdirectory
+	renderTemplate(w, "tasks-tid-edit", data)
+}
+
+// The handler applies the submitted changes to the definition of a scheduled,
+// one time or event task (see `editTaskGroup`). In the end the user is
+// redirected to the overview page of the tasks. In case of an error the
+// errorhandler is called.
+func handleTasksTidEditPost(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	if _, err := editTaskGroup(r, vars["tid"], token); err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	http.Redirect(w, r, fmt.Sprintf("%stasks/", application_subdirectory),
+		http.StatusFound)
+}
+
+// The handler pauses the scheduled, event or one time task, i.e. its
+// scheduling go routine is stopped or, in case of an event task, the deliveries
+// of its hook are ignored. The hook itself and the running executions are
+// kept. In the end the user is redirected to the overview page of the tasks.
+// In case of an error the errorhandler is called.
+func handleTasksTidPause(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	task, err := db.PauseTaskGroup(vars["tid"], token)
+	if err != nil {
+		handleError(w, r, err)
+		return
+	}
+
+	switch task.(type) {
+	case *db.ScheduledTask:
+		worker.PauseScheduledTask(task.(*db.ScheduledTask).Id)
+	case *db.OneTimeTask:
+		worker.PauseOneTimeTask(task.(*db.OneTimeTask).Id)
 	}
+
+	http.Redirect(w, r, fmt.Sprintf("%stasks/", application_subdirectory),
+		http.StatusFound)
+}
+
+// The handler resumes the paused scheduled, event or one time task. The
+// scheduling go routine is started again, i.e. the next execution time of a
+// scheduled task is computed afresh and a one time task whose date passed is
+// executed right away. In the end the user is redirected to the overview page
+// of the tasks. In case of an error the errorhandler is called.
+func handleTasksTidResume(w http.ResponseWriter, r *http.Request,
+	vars map[string]string, session *sessions.Session, token string) {
+	task, err := db.ResumeTaskGroup(vars["tid"], token)
 	if err != nil {
 		handleError(w, r, err)
 		return
 	}
 
+	switch task.(type) {
+	case *db.ScheduledTask:
+		worker.RunScheduledTask(task.(*db.ScheduledTask).Id)
+	case *db.OneTimeTask:
+		worker.RunOneTimeTask(task.(*db.OneTimeTask).Id)
+	}
+
 	http.Redirect(w, r, fmt.Sprintf("%stasks/", application_subdirectory),
 		http.StatusFound)
 }
@@ -1569,6 +2936,16 @@ func handleAPIPostBot(w http.ResponseWriter, r *http.Request, token string) {
 		http.Error(w, "Invalid input for Bot creation!", http.StatusNotFound)
 		return
 	}
+	max_retries, err := parseRetries(r.FormValue("max_retries"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	timeout, err := parseTimeout(r.FormValue("timeout"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	resp, err := http.Get(
 		fmt.Sprintf("https://index.docker.io/v1/repositories/%s/tags", path))
 	if err != nil {
@@ -1580,7 +2957,8 @@ func handleAPIPostBot(w http.ResponseWriter, r *http.Request, token string) {
 		http.Error(w, "Docker Hub entry does not exist!", http.StatusNotFound)
 		return
 	}
-	if bid, err := db.AddBot(path, description, tags); err != nil {
+	if bid, err := db.AddBot(path, description, tags,
+		r.FormValue("requirements"), max_retries, timeout); err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	} else {
@@ -1665,6 +3043,67 @@ func handleAPIGetTask(w http.ResponseWriter, r *http.Request, token string) {
 	}
 }
 
+// Streams the output of the Task specified by the "tid" parameter as
+// Server-Sent Events (see `streamTaskOutput`).
+func handleAPIGetTaskOutput(w http.ResponseWriter, r *http.Request,
+	token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	task, err := db.GetTask(r.FormValue("tid"), user_token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	streamTaskOutput(w, r, task)
+}
+
+// Retrieves the artifacts of the Task specified by the "tid" parameter,
+// marshals them as JSON array and sends them back.
+func handleAPIGetArtifacts(w http.ResponseWriter, r *http.Request,
+	token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	artifacts, err := db.GetArtifacts(user_token, r.FormValue("tid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if artifacts == nil {
+		artifacts = []*db.Artifact{}
+	}
+	js, err := json.Marshal(artifacts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
+
+// Sends the artifact specified by the "aid" parameter of the Task specified by
+// the "tid" parameter.
+func handleAPIGetArtifact(w http.ResponseWriter, r *http.Request,
+	token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	artifact, err := db.GetArtifact(user_token, r.FormValue("tid"),
+		r.FormValue("aid"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	sendArtifact(w, artifact)
+}
+
 // Validates the user's input and adds a new Task to the database. After
 // successful insertion the newly created Task is retrieved again, marshaled as
 // JSON object and sent back.
@@ -1696,36 +3135,178 @@ func handleAPIPostTask(w http.ResponseWriter, r *http.Request, token string) {
 	}
 }
 
-// Cancels the specified task, if it is pending or running.
+// Cancels the specified task of the user, if it is pending or running.
+// Responds with 404 if the user has no such task.
 func handleAPIDeleteTask(w http.ResponseWriter, r *http.Request, token string) {
-	tid, _ := strconv.ParseInt(r.FormValue("tid"), 10, 64)
-	worker.Cancel(tid)
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	task, err := db.GetTask(r.FormValue("tid"), user_token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	worker.Cancel(task.Id, db.Canceled_by_user, actorName(user_token))
+}
+
+// Sets the draining state of the user's worker (specified by the "token"
+// parameter) to the value of the "drain" parameter (default: true). If the
+// "deregister" parameter is set the worker is deleted after draining. The
+// updated worker is marshaled as JSON object and sent back.
+func handleAPIPostWorkerDrain(w http.ResponseWriter, r *http.Request,
+	token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	draining := true
+	if r.FormValue("drain") != "" {
+		if draining, err = strconv.ParseBool(r.FormValue("drain")); err != nil {
+			http.Error(w, "Invalid drain parameter!", http.StatusBadRequest)
+			return
+		}
+	}
+	deregister, _ := strconv.ParseBool(r.FormValue("deregister"))
+
+	drained, err := drainWorker(user_token, r.FormValue("token"), draining,
+		deregister)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	js, err := json.Marshal(drained)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
+
+// Retrieves the settings of a task group (specified by the "tid" GET parameter)
+// of the user from the database and marshals them as JSON object.
+func handleAPIGetTaskGroup(w http.ResponseWriter, r *http.Request,
+	token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	settings, err := db.GetTaskGroupSettings(r.FormValue("tid"), user_token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+	} else {
+		js, err := json.Marshal(settings)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		} else {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(js)
+		}
+	}
+}
+
+// Validates the user's input and updates the settings of a task group
+// (specified by the "tid" parameter). Settings that are not submitted are kept.
+// The updated settings are marshaled as JSON object and sent back.
+func handleAPIPostTaskGroup(w http.ResponseWriter, r *http.Request,
+	token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	settings, err := db.GetTaskGroupSettings(r.FormValue("tid"), user_token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err := parseTaskGroupSettings(r, settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := db.UpdateTaskGroupSettings(user_token, settings); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	js, err := json.Marshal(settings)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
+
+// Validates the user's input and edits the definition of a scheduled, one time
+// or event task (specified by the "tid" parameter, see `editTaskGroup`). The
+// edited task group is marshaled as JSON object and sent back. Responds with
+// 404 if the task is not known and with 400 if the input is invalid.
+func handleAPIPostTaskGroupEdit(w http.ResponseWriter, r *http.Request,
+	token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	group, err := editTaskGroup(r, r.FormValue("tid"), user_token)
+	switch {
+	case err == unknownTaskGroup:
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case err == hookUpdateFailure:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	js, err := json.Marshal(group)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
 }
 
 // Retrieves all Tasks of the user from the database and marshals them as JSON
-// object.
+// object. The query parameter `worker` restricts the tasks to those executed by
+// the worker with this id.
 func handleAPIGetTasks(w http.ResponseWriter, r *http.Request, token string) {
 	user_token, err := db.GetUserTokenFromAPIToken(token)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 	}
 
-	scheduled, err := db.GetScheduledTasks(user_token)
+	wid, err := parseWorkerFilter(r.FormValue("worker"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	scheduled, err := db.GetScheduledTasks(user_token, wid)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	event, err := db.GetEventTasks(user_token)
+	event, err := db.GetEventTasks(user_token, wid)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	instant, err := db.GetInstantTasks(user_token)
+	instant, err := db.GetInstantTasks(user_token, wid)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
 	}
-	one_time, err := db.GetOneTimeTasks(user_token)
+	one_time, err := db.GetOneTimeTasks(user_token, wid)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusNotFound)
 		return
@@ -1745,3 +3326,33 @@ func handleAPIGetTasks(w http.ResponseWriter, r *http.Request, token string) {
 		w.Write(js)
 	}
 }
+
+// Retrieves the queue entries of the user's pending tasks along with the depth
+// of the whole queue and marshals them as JSON object. The query parameter
+// `page` selects the page of the entries (see `queue_page_size`).
+func handleAPIGetQueue(w http.ResponseWriter, r *http.Request, token string) {
+	user_token, err := db.GetUserTokenFromAPIToken(token)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	page, err := parseQueuePage(r.FormValue("page"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	queue, err := db.GetQueue(user_token, (page-1)*queue_page_size,
+		queue_page_size)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	js, err := json.Marshal(queue)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	} else {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(js)
+	}
+}
@@ -20,7 +20,8 @@ CREATE TABLE users(
 	email varchar(50),
 	token varchar(50) NOT NULL UNIQUE CHECK (token <> ''),
 	worker_token varchar(50) NOT NULL UNIQUE CHECK (worker_token <> ''),
-	admin boolean
+	admin boolean,
+	share_weight integer NOT NULL DEFAULT 1 CHECK (share_weight > 0)
 );
 
 CREATE TABLE api_tokens(
@@ -40,7 +41,10 @@ CREATE TABLE bots(
 	name varchar(50) NOT NULL UNIQUE CHECK (name <> ''),
 	description text,
 	tags varchar(20)[],
-	fs_path varchar(100)
+	fs_path varchar(100),
+	max_retries integer NOT NULL DEFAULT 2 CHECK (max_retries >= 0),
+	requirements varchar(50)[],
+	timeout integer NOT NULL DEFAULT 600 CHECK (timeout > 0)
 );
 
 CREATE TABLE projects(
@@ -58,12 +62,19 @@ CREATE TABLE workers(
 	name varchar(50) NOT NULL,
 	last_contact timestamp NOT NULL,
 	active boolean NOT NULL,
-	shared boolean NOT NULL
+	shared boolean NOT NULL,
+	labels varchar(50)[],
+	cert_fingerprint varchar(64),
+	draining boolean NOT NULL DEFAULT false,
+	delete_after_drain boolean NOT NULL DEFAULT false,
+	capacity integer NOT NULL DEFAULT 1 CHECK (capacity > 0)
 );
 
 CREATE TABLE members(
 	uid integer REFERENCES users(id) NOT NULL,
 	pid integer REFERENCES projects(id) NOT NULL,
+	routing integer NOT NULL DEFAULT 0,
+	allowed_workers integer[],
 	PRIMARY KEY (uid, pid)
 );
 
@@ -71,7 +82,13 @@ CREATE TABLE group_tasks(
 	id SERIAL PRIMARY KEY NOT NULL,
 	uid integer REFERENCES users(id) NOT NULL,
 	pid integer REFERENCES projects(id) NOT NULL,
-	bid integer REFERENCES bots(id) NOT NULL
+	bid integer REFERENCES bots(id) NOT NULL,
+	max_retries integer CHECK (max_retries >= 0),
+	priority integer NOT NULL DEFAULT 0 CHECK (priority BETWEEN -50 AND 50),
+	timeout integer CHECK (timeout > 0),
+	routing integer,
+	allowed_workers integer[],
+	overlap integer NOT NULL DEFAULT 0
 );
 
 CREATE TABLE tasks(
@@ -81,8 +98,66 @@ CREATE TABLE tasks(
 	end_time timestamp,
 	status integer NOT NULL,
 	exit_status integer,
-	output text,
-	patch varchar(100) NOT NULL
+	stdout text,
+	stderr text,
+	stdout_truncated boolean NOT NULL DEFAULT false,
+	stderr_truncated boolean NOT NULL DEFAULT false,
+	metadata text,
+	failure_reason text,
+	patch varchar(100) NOT NULL,
+	parent integer REFERENCES tasks(id),
+	attempt integer NOT NULL DEFAULT 1,
+	infra_failure boolean NOT NULL DEFAULT false,
+	not_before timestamp,
+	priority integer NOT NULL DEFAULT 0,
+	clone_token varchar(50) UNIQUE,
+	deadline timestamp,
+	cancel_reason text,
+	canceled_by varchar(50),
+	wid integer REFERENCES workers(id) ON DELETE SET NULL,
+	worker_hostname varchar(255),
+	catch_up_time timestamp
+);
+
+CREATE TABLE task_leases(
+	tid integer PRIMARY KEY REFERENCES tasks(id) NOT NULL,
+	wid integer REFERENCES workers(id) ON DELETE CASCADE NOT NULL,
+	expires timestamp NOT NULL
+);
+
+CREATE TABLE executions(
+	id SERIAL PRIMARY KEY NOT NULL,
+	tid integer REFERENCES tasks(id) NOT NULL,
+	wid integer REFERENCES workers(id) ON DELETE SET NULL,
+	assigned timestamp NOT NULL DEFAULT now()
+);
+
+CREATE TABLE worker_connections(
+	id SERIAL PRIMARY KEY NOT NULL,
+	wid integer REFERENCES workers(id) ON DELETE SET NULL,
+	connected timestamp NOT NULL,
+	disconnected timestamp
+);
+
+CREATE TABLE task_output_chunks(
+	tid integer REFERENCES tasks(id) ON DELETE CASCADE NOT NULL,
+	seq integer NOT NULL CHECK (seq >= 0),
+	stream varchar(10) NOT NULL CHECK (stream IN ('stdout', 'stderr')),
+	content text NOT NULL,
+	PRIMARY KEY (tid, seq)
+);
+
+CREATE TABLE artifacts(
+	id SERIAL PRIMARY KEY NOT NULL,
+	tid integer REFERENCES tasks(id) NOT NULL,
+	name varchar(255) NOT NULL CHECK (name <> ''),
+	file_name varchar(100) NOT NULL UNIQUE,
+	content_type varchar(100) NOT NULL,
+	size bigint NOT NULL DEFAULT 0,
+	parts integer NOT NULL DEFAULT 0,
+	sha256 varchar(64),
+	complete boolean NOT NULL DEFAULT false,
+	UNIQUE (tid, name)
 );
 
 CREATE TABLE schedule_tasks(
@@ -90,7 +165,10 @@ CREATE TABLE schedule_tasks(
 	name varchar(50) NOT NULL,
 	status integer NOT NULL,
 	next timestamp,
-	cron varchar(100) NOT NULL CHECK (cron <> '')
+	cron varchar(100) NOT NULL CHECK (cron <> ''),
+	timezone varchar(64) NOT NULL DEFAULT 'UTC',
+	misfire integer NOT NULL DEFAULT 0,
+	misfire_limit integer NOT NULL DEFAULT 1 CHECK (misfire_limit > 0)
 );
 
 CREATE TABLE onetime_tasks(
@@ -123,6 +201,11 @@ ALTER TABLE workers OWNER TO :db_user;
 ALTER TABLE members OWNER TO :db_user;
 ALTER TABLE group_tasks OWNER TO :db_user;
 ALTER TABLE tasks OWNER TO :db_user;
+ALTER TABLE task_leases OWNER TO :db_user;
+ALTER TABLE executions OWNER TO :db_user;
+ALTER TABLE worker_connections OWNER TO :db_user;
+ALTER TABLE task_output_chunks OWNER TO :db_user;
+ALTER TABLE artifacts OWNER TO :db_user;
 ALTER TABLE schedule_tasks OWNER TO :db_user;
 ALTER TABLE onetime_tasks OWNER TO :db_user;
 ALTER TABLE instant_tasks OWNER TO :db_user;
@@ -2,6 +2,9 @@
 package db
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,6 +23,34 @@ const (
 	Canceled  = iota
 	Succeeded = iota
 	Failed    = iota
+	TimedOut  = iota
+)
+
+// Reasons for canceling a task (see `Task.Cancel_reason`)
+const (
+	Canceled_by_user         = "The task was canceled."
+	Canceled_with_group      = "The task's action was canceled."
+	Canceled_by_deregistered = "The worker executing the task was deregistered."
+	Canceled_by_newer        = "The task was superseded by a newer execution."
+)
+
+// Actor recorded for tasks the platform stopped on its own (e.g. timeouts)
+const Platform_actor = "platform"
+
+// Output streams of a task
+const (
+	Stdout_stream = "stdout"
+	Stderr_stream = "stderr"
+)
+
+// Base priorities of tasks depending on the kind of their task group. Tasks
+// with a higher priority are assigned to workers first. Users can raise or
+// lower the priority of a task group by at most `Max_priority_adjustment`.
+const (
+	Instant_priority        = 300
+	Scheduled_priority      = 200
+	Event_priority          = 100
+	Max_priority_adjustment = 50
 )
 
 // Github Events
@@ -74,6 +105,7 @@ var Event_names = [...]string{
 const (
 	Active   = iota
 	Complete = iota
+	Paused   = iota
 )
 
 // Trigger for a task
@@ -86,6 +118,43 @@ const (
 	Event   = iota // when event occurs
 )
 
+// Routing policies of a project or task group (see `RoutingRule`)
+const (
+	// the user's own workers are preferred, shared workers are used otherwise
+	Routing_shared_allowed = iota
+	// only the user's own workers execute the tasks
+	Routing_private_only = iota
+)
+
+// Overlap policies of a task group, i.e. how a new execution is handled while a
+// previous execution of the group is still pending or running (see
+// `TaskGroupSettings.Overlap`)
+const (
+	// the executions run concurrently
+	Overlap_allow = iota
+	// no new execution is created
+	Overlap_skip = iota
+	// the previous executions are canceled
+	Overlap_cancel_previous = iota
+	// the new execution waits until the running one finished, no new
+	// execution is created if one is waiting already
+	Overlap_queue_one = iota
+)
+
+// Misfire policies of a scheduled task, i.e. how the executions that were
+// missed while the platform was down are handled (see `ScheduledTask.Misfire`)
+const (
+	// the missed executions are dropped
+	Misfire_skip = iota
+	// the latest missed execution is caught up
+	Misfire_run_once = iota
+	// the latest `ScheduledTask.Misfire_limit` missed executions are caught up
+	Misfire_run_all = iota
+)
+
+// Maximal number of missed executions of a scheduled task that are caught up
+const Max_misfire_limit = 100
+
 //
 // ## Data Structures ##
 //
@@ -100,6 +169,9 @@ type User struct {
 	Token        string
 	Worker_token string
 	Admin        bool
+	// Weight of the user's share of the shared workers (see
+	// `GetPendingTask`)
+	Share_weight int64
 }
 
 // User statistics
@@ -136,11 +208,15 @@ type Project struct {
 
 // Analysis bot
 type Bot struct {
-	Id          int64
-	Name        string
-	Description string
-	Tags        []string
-	Fs_path     string
+	Id           int64
+	Name         string
+	Description  string
+	Tags         []string
+	Fs_path      string
+	Max_retries  int64
+	Requirements []string
+	// Maximal duration in seconds of an execution
+	Timeout int64
 }
 
 // User project relation
@@ -159,17 +235,80 @@ type group_task struct {
 
 // A task is a bot's execution on a project
 type Task struct {
+	Id               int64
+	Gid              int64
+	User             *User
+	Project          *Project
+	Bot              *Bot
+	Start_time       *time.Time
+	End_time         *time.Time
+	Status           int64
+	Exit_status      int64
+	Stdout           string
+	Stderr           string
+	Stdout_truncated bool
+	Stderr_truncated bool
+	Metadata         map[string]string
+	Failure_reason   string
+	Patch            string
+	Parent           int64
+	Attempt          int64
+	Infra_failure    bool
+	Not_before       *time.Time
+	Priority         int64
+	Deadline         *time.Time
+	// Why and by whom (a user name or `Platform_actor`) a canceled or timed
+	// out task was stopped
+	Cancel_reason string
+	Canceled_by   string
+	// Worker the task was assigned to (0 if it was never assigned or the
+	// worker was deleted) and the host name it reported when starting the
+	// task
+	Wid             int64
+	Worker_name     string
+	Worker_hostname string
+	// Routing rule in effect for the task (see `EffectiveRoutingRule`)
+	Routing *RoutingRule
+	// Time the task was due if it catches up an execution that was missed
+	// while the platform was down (nil otherwise)
+	Catch_up_time *time.Time
+}
+
+// Result of a task's execution as reported by the worker. `Metadata` describes
+// the execution environment (e.g. the worker's version or the bot's image).
+// The truncation flags are set if the corresponding output exceeded the size
+// limit and was shortened.
+type TaskResult struct {
+	Stdout           string
+	Stderr           string
+	Stdout_truncated bool
+	Stderr_truncated bool
+	Metadata         map[string]string
+	Exit_status      int
+	Infra_failure    bool
+}
+
+// Execution settings of a task group (scheduled, one time, instant or event
+// task)
+type TaskGroupSettings struct {
 	Id          int64
-	Gid         int64
-	User        *User
-	Project     *Project
 	Bot         *Bot
-	Start_time  *time.Time
-	End_time    *time.Time
-	Status      int64
-	Exit_status int64
-	Output      string
-	Patch       string
+	Max_retries *int64
+	Priority    int64
+	Timeout     *int64
+	// nil if the routing rule of the project applies
+	Routing *RoutingRule
+	// How a new execution is handled while a previous one is still active
+	Overlap int64
+}
+
+// Rule restricting the workers that may execute the tasks of a project or a
+// task group. The user sets a rule per project which can be overridden for
+// each of the project's task groups.
+type RoutingRule struct {
+	Policy int64
+	// Ids of the workers that may execute the tasks (any worker if empty)
+	Workers []int64
 }
 
 // Scheduled task
@@ -182,6 +321,13 @@ type ScheduledTask struct {
 	Status  int64
 	Next    time.Time
 	Cron    string
+	// IANA time zone the cron expression is evaluated in (e.g.
+	// "Europe/Berlin")
+	Timezone string
+	// How executions missed while the platform was down are handled and how
+	// many of them are caught up at most (`Misfire_run_all` only)
+	Misfire       int64
+	Misfire_limit int64
 }
 
 // Scheduled task with its executions
@@ -249,6 +395,106 @@ type Worker struct {
 	Last_contact time.Time
 	Active       bool
 	Shared       bool
+	Labels       []string
+	// hex encoded SHA-256 fingerprint of the client certificate the worker
+	// must present on the worker port (empty if not bound to a certificate)
+	Cert_fingerprint string
+	// draining workers finish their current task but get no new tasks
+	Draining           bool
+	Delete_after_drain bool
+	// number of tasks the worker executes concurrently at most and number of
+	// tasks it currently executes
+	Capacity int64
+	Occupied int64
+}
+
+// Statistics of the executions of a worker. `Average_duration` is the average
+// duration in seconds of the executions that finished (i.e. succeeded or
+// failed).
+type Worker_statistics struct {
+	Executions       int64
+	Succeeded        int64
+	Failed           int64
+	Canceled         int64
+	Timed_out        int64
+	Average_duration float64
+}
+
+// Worker along with the statistics of its executions
+type Worker_summary struct {
+	Worker     *Worker
+	Statistics *Worker_statistics
+}
+
+// Assignment of a task to a worker. `Wid` is 0 if the worker was deleted.
+type Execution struct {
+	Task     *Task
+	Wid      int64
+	Assigned time.Time
+}
+
+// Period of time a worker was connected to the platform. `Disconnected` is nil
+// while the worker is connected.
+type Worker_connection struct {
+	Connected    time.Time
+	Disconnected *time.Time
+}
+
+// Position of a pending task in the queue of tasks waiting for a worker.
+// `Eligible_workers` counts the active workers that are able and permitted to
+// execute the task (see `RoutingRule`), `Free_slots` their capacity that is not
+// in use. `Estimated_start` is nil if the start cannot be estimated (e.g.
+// because there is no eligible worker).
+type Queue_entry struct {
+	Task             *Task
+	Position         int64
+	Eligible_workers int64
+	Free_slots       int64
+	Estimated_start  *time.Time
+}
+
+// Queue of the pending tasks. `Depth` is the number of tasks of all users that
+// wait for a worker, `Entries` may be restricted to the tasks of one user and
+// to a page of them. `Total` counts the entries of all pages.
+type Queue struct {
+	Depth   int64
+	Total   int64
+	Entries []*Queue_entry
+}
+
+// Lease on a task held by the worker executing it
+type Lease struct {
+	Tid          int64
+	Wid          int64
+	Worker_token string
+	Expires      time.Time
+}
+
+// Part of the output of a task sent by the worker while executing the task.
+// `Seq` numbers the chunks of a task in the order they were produced.
+// `Stream` is either `Stdout_stream` or `Stderr_stream`.
+type OutputChunk struct {
+	Tid     int64
+	Seq     int64
+	Stream  string
+	Content string
+}
+
+// File produced by a task (e.g. a report) besides its output and Git patch.
+// `File_name` is the name of the file in the storage. While the artifact is
+// uploaded its content is stored in `Parts` separate files. An artifact is
+// `Complete` once its upload finished and its checksum (`Sha256`) was
+// verified.
+type Artifact struct {
+	Id           int64
+	Tid          int64
+	Name         string
+	File_name    string
+	Content_type string
+	Size         int64
+	Parts        int64
+	Sha256       string
+	Complete     bool
 }
 
 //
@@ -270,6 +516,8 @@ func (t *Task) StatusString() string {
 		return "Succeeded"
 	case t.Status == Failed:
 		return "Failed"
+	case t.Status == TimedOut:
+		return "Timed out"
 	default:
 		return "Ups! This should not happen ..."
 	}
@@ -282,6 +530,8 @@ func task_group_status_string(status int64) string {
 		return "Active"
 	case status == Complete:
 		return "Complete"
+	case status == Paused:
+		return "Paused"
 	default:
 		return "Ups! This should not happen ..."
 	}
@@ -297,11 +547,37 @@ func (t *ScheduledTask) IsActive() bool {
 	return t.Status == Active
 }
 
+// Returns the next execution time in the time zone of the schedule
+func (t *ScheduledTask) LocalNext() time.Time {
+	location, err := time.LoadLocation(t.Timezone)
+	if err != nil {
+		return t.Next
+	}
+	return t.Next.In(location)
+}
+
 // Checks if the task is complete
 func (t *ScheduledTask) IsComplete() bool {
 	return t.Status == Complete
 }
 
+// Checks if the task is paused
+func (t *ScheduledTask) IsPaused() bool {
+	return t.Status == Paused
+}
+
+// Converts the misfire policy to a user friendly description
+func (t *ScheduledTask) MisfireString() string {
+	switch t.Misfire {
+	case Misfire_run_once:
+		return "Run once"
+	case Misfire_run_all:
+		return fmt.Sprintf("Run all (at most %d)", t.Misfire_limit)
+	default:
+		return "Skip"
+	}
+}
+
 // Converts the status of a task to the corresponding string representation
 func (t *EventTask) StatusString() string {
 	return task_group_status_string(t.Status)
@@ -317,6 +593,11 @@ func (t *EventTask) IsComplete() bool {
 	return t.Status == Complete
 }
 
+// Checks if the task is paused
+func (t *EventTask) IsPaused() bool {
+	return t.Status == Paused
+}
+
 // Converts the status of a task to the corresponding string representation
 func (t *OneTimeTask) StatusString() string {
 	return task_group_status_string(t.Status)
@@ -332,6 +613,11 @@ func (t *OneTimeTask) IsComplete() bool {
 	return t.Status == Complete
 }
 
+// Checks if the task is paused
+func (t *OneTimeTask) IsPaused() bool {
+	return t.Status == Paused
+}
+
 // Converts the event of a task to the corresponding string representation
 func (t *EventTask) EventString() string {
 	switch {
@@ -409,3 +695,163 @@ func (t *Task) IsSucceeded() bool {
 func (t *Task) IsFailed() bool {
 	return t.Status == Failed
 }
+
+// Check if the task timed out
+func (t *Task) IsTimedOut() bool {
+	return t.Status == TimedOut
+}
+
+// Percentage of the worker's completed executions (i.e. executions that were
+// not canceled) that succeeded
+func (s *Worker_statistics) SuccessRate() float64 {
+	completed := s.Succeeded + s.Failed + s.Timed_out
+	if completed == 0 {
+		return 0
+	}
+	return float64(s.Succeeded) * 100 / float64(completed)
+}
+
+// Percentage of the worker's completed executions (i.e. executions that were
+// not canceled) that failed or timed out
+func (s *Worker_statistics) FailureRate() float64 {
+	completed := s.Succeeded + s.Failed + s.Timed_out
+	if completed == 0 {
+		return 0
+	}
+	return float64(s.Failed+s.Timed_out) * 100 / float64(completed)
+}
+
+// Checks whether the worker is connected to the platform (any longer)
+func (c *Worker_connection) IsOpen() bool {
+	return c.Disconnected == nil
+}
+
+// Returns the rule of the task group if it has one, the rule of the project
+// otherwise. Without any rule shared workers are allowed.
+func EffectiveRoutingRule(group, project *RoutingRule) *RoutingRule {
+	switch {
+	case group != nil:
+		return group
+	case project != nil:
+		return project
+	default:
+		return &RoutingRule{Policy: Routing_shared_allowed}
+	}
+}
+
+// Checks whether the worker may execute the tasks of the user `uid` according
+// to the rule
+func (r *RoutingRule) Permits(worker *Worker, uid int64) bool {
+	if r.Policy == Routing_private_only && worker.Uid != uid {
+		return false
+	}
+
+	return len(r.Workers) == 0 || r.Allows(worker.Id)
+}
+
+// Checks whether the worker is on the allow-list of the rule
+func (r *RoutingRule) Allows(wid int64) bool {
+	for _, allowed := range r.Workers {
+		if allowed == wid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Checks if only the user's own workers may execute the tasks
+func (r *RoutingRule) IsPrivateOnly() bool {
+	return r.Policy == Routing_private_only
+}
+
+// Describes the rule in a human readable way
+func (r *RoutingRule) String() string {
+	policy := "Shared workers allowed"
+	if r.IsPrivateOnly() {
+		policy = "Private workers only"
+	}
+	if len(r.Workers) == 0 {
+		return policy
+	}
+
+	return fmt.Sprintf("%s, restricted to %d selected workers", policy,
+		len(r.Workers))
+}
+
+// Check if the task is a retry of a failed task
+func (t *Task) IsRetry() bool {
+	return t.Parent != 0
+}
+
+// Check if the task catches up an execution missed while the platform was down
+func (t *Task) IsCatchUp() bool {
+	return t.Catch_up_time != nil
+}
+
+// Checks whether the worker meets all of the given requirements. A requirement
+// is either a plain label (e.g. "gpu") or a key-value pair (e.g. "arch=arm64").
+// A plain label is met if the worker advertises a label with the same name
+// regardless of its value. A key-value pair is met if the worker advertises
+// the same key with the same value. If both values are sizes (e.g. "mem=16g")
+// the worker's value must be at least as large as the required one.
+func (w *Worker) Satisfies(requirements []string) bool {
+	labels := make(map[string]string)
+	for _, label := range w.Labels {
+		key, value := splitLabel(label)
+		labels[key] = value
+	}
+
+	for _, requirement := range requirements {
+		key, required := splitLabel(requirement)
+		if key == "" {
+			continue
+		}
+		value, ok := labels[key]
+		if !ok {
+			return false
+		}
+		if required == "" || value == required {
+			continue
+		}
+		have, err_have := parseSize(value)
+		need, err_need := parseSize(required)
+		if err_have != nil || err_need != nil || have < need {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Splits a label into its (lower case) key and value. The value of a plain
+// label is empty.
+func splitLabel(label string) (string, string) {
+	parts := strings.SplitN(strings.ToLower(strings.TrimSpace(label)), "=", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+}
+
+// Converts a size such as "512m" or "16g" into a number. Plain numbers are
+// returned unchanged.
+func parseSize(value string) (float64, error) {
+	factor := 1.0
+	switch {
+	case strings.HasSuffix(value, "k"):
+		factor = 1 << 10
+	case strings.HasSuffix(value, "m"):
+		factor = 1 << 20
+	case strings.HasSuffix(value, "g"):
+		factor = 1 << 30
+	case strings.HasSuffix(value, "t"):
+		factor = 1 << 40
+	}
+	if factor != 1 {
+		value = value[:len(value)-1]
+	}
+
+	number, err := strconv.ParseFloat(value, 64)
+	return number * factor, err
+}
@@ -2,7 +2,6 @@
 package db
 
 import (
-	"bytes"
 	"crypto/sha1"
 	"database/sql"
 	"encoding/json"
@@ -80,6 +79,48 @@ func makeString(in interface{}) string {
 	}
 }
 
+// This function returns the `values` as an array parameter (escaped by the pq
+// driver). Surrounding white space is trimmed and empty values are skipped.
+func makeArray(values []string) pq.StringArray {
+	array := pq.StringArray{}
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		array = append(array, value)
+	}
+
+	return array
+}
+
+// This function returns the values of the array literal `in`
+func parseArray(in sql.NullString) []string {
+	var array pq.StringArray
+	if !in.Valid || array.Scan(in.String) != nil || len(array) == 0 {
+		return nil
+	}
+
+	return array
+}
+
+// This function returns the ids as an array parameter
+func makeIdArray(ids []int64) pq.Int64Array {
+	return append(pq.Int64Array{}, ids...)
+}
+
+// This function returns the ids of the array literal `in`
+func parseIdArray(in sql.NullString) []int64 {
+	var ids []int64
+	for _, value := range parseArray(in) {
+		if id, err := strconv.ParseInt(value, 10, 64); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	return ids
+}
+
 // Generates a sequence of random characters (`letterBytes`) of length `n` such
 // that it is unique within a particular data set. Thus `db_query` must be
 // passed where the sequence can be substituted in terms of `sql.QueryRow`. The
@@ -136,7 +177,8 @@ func GetUser(token string) (*User, error) {
 	// fetch user
 	if err := db.QueryRow("SELECT * FROM users WHERE token=$1", token).
 		Scan(&user.Id, &user.GH_Id, &user_name, &real_name, &email,
-		&user.Token, &user.Worker_token, &user.Admin); err != nil {
+		&user.Token, &user.Worker_token, &user.Admin,
+		&user.Share_weight); err != nil {
 		return nil, err
 	}
 
@@ -164,7 +206,8 @@ func getUser(uid string, token string) (*User, error) {
 	// fetch user and verify token
 	if err := db.QueryRow("SELECT * FROM users WHERE id=$1 AND token=$2", uid,
 		token).Scan(&user.Id, &user.GH_Id, &user_name, &real_name, &email,
-		&user.Token, &user.Worker_token, &user.Admin); err != nil {
+		&user.Token, &user.Worker_token, &user.Admin,
+		&user.Share_weight); err != nil {
 		return nil, err
 	}
 
@@ -212,6 +255,49 @@ func createUser(user *User) {
 		user.Worker_token, user.Admin).Scan(&dummy)
 }
 
+// This function returns all users ordered by their id
+func GetUsers() ([]*User, error) {
+	var users []*User
+
+	rows, err := db.Query("SELECT id, gh_id, username, realname, admin, " +
+		"share_weight FROM users ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := User{}
+		var user_name, real_name sql.NullString
+		if err := rows.Scan(&user.Id, &user.GH_Id, &user_name, &real_name,
+			&user.Admin, &user.Share_weight); err != nil {
+			return nil, err
+		}
+		if user_name.Valid {
+			user.User_name = user_name.String
+		}
+		if real_name.Valid {
+			user.Real_name = real_name.String
+		}
+		users = append(users, &user)
+	}
+
+	return users, nil
+}
+
+// This function sets the weight of the user's share of the shared workers
+// (see `GetPendingTask`). The weight must be positive.
+func SetUserShareWeight(uid string, weight int64) error {
+	var dummy string
+
+	if weight <= 0 {
+		return errors.New("The share weight must be positive!")
+	}
+
+	return db.QueryRow("UPDATE users SET share_weight = $1 WHERE id = $2 "+
+		"RETURNING id", weight, uid).Scan(&dummy)
+}
+
 // Fetch all user specific statistics from the database.
 func GetUserStatistics(token string) (*User_statistics, error) {
 	// declarations
@@ -480,6 +566,63 @@ func GetProject(pid string, token string) (*Project, error) {
 	return &project, nil
 }
 
+// This function returns the routing rule the user set for the project. Fails
+// if the user is not a member of the project.
+func GetProjectRouting(pid, token string) (*RoutingRule, error) {
+	var routing sql.NullInt64
+	var allowed_workers sql.NullString
+
+	if err := db.QueryRow("SELECT members.routing, members.allowed_workers "+
+		"FROM members INNER JOIN users ON members.uid = users.id "+
+		"WHERE members.pid = $1 AND users.token = $2", pid, token).
+		Scan(&routing, &allowed_workers); err != nil {
+		return nil, err
+	}
+
+	return makeRoutingRule(routing, allowed_workers), nil
+}
+
+// This function stores the routing rule the user set for the project. It
+// applies to all task groups of the user on this project that do not have a
+// rule of their own.
+func UpdateProjectRouting(pid, token string, rule *RoutingRule) error {
+	var dummy string
+
+	if err := checkRoutingRule(token, rule); err != nil {
+		return err
+	}
+
+	return db.QueryRow("UPDATE members SET routing = $1, "+
+		"allowed_workers = $2 WHERE pid = $3 "+
+		"AND uid = (SELECT id FROM users WHERE token = $4) RETURNING pid",
+		rule.Policy, makeIdArray(rule.Workers), pid, token).Scan(&dummy)
+}
+
+// This function checks that the rule has a known policy and only allows
+// workers the user may use, i.e. the user's own and shared workers.
+func checkRoutingRule(token string, rule *RoutingRule) error {
+	if rule.Policy != Routing_shared_allowed &&
+		rule.Policy != Routing_private_only {
+		return errors.New("Unknown routing policy!")
+	}
+	if len(rule.Workers) == 0 {
+		return nil
+	}
+
+	var count int
+	if err := db.QueryRow("SELECT count(*) FROM workers "+
+		"WHERE id = ANY($1) "+
+		"AND (shared OR uid = (SELECT id FROM users WHERE token = $2))",
+		makeIdArray(rule.Workers), token).Scan(&count); err != nil {
+		return err
+	}
+	if count != len(rule.Workers) {
+		return errors.New("Only your own and shared workers can be allowed!")
+	}
+
+	return nil
+}
+
 // This function checks whether a Project exists for the given Github ID
 func existsProject(gh_id int64) bool {
 	err := db.QueryRow("SELECT gh_id FROM projects WHERE gh_id = $1", gh_id).
@@ -512,8 +655,9 @@ func fillProject(project *Project, uid int64) error {
 	}
 
 	// update member relation
-	if err := db.QueryRow("SELECT * FROM members WHERE uid=$1 AND pid=$2", uid,
-		project.Id).Scan(&uid, &project.Id); err == sql.ErrNoRows {
+	if err := db.QueryRow("SELECT uid, pid FROM members "+
+		"WHERE uid=$1 AND pid=$2", uid, project.Id).
+		Scan(&uid, &project.Id); err == sql.ErrNoRows {
 		var dummy string
 		db.QueryRow("INSERT INTO members VALUES ($1, $2)", uid, project.Id).
 			Scan(&dummy)
@@ -562,28 +706,26 @@ func createProject(project *Project, uid int64) error {
 
 // This function inserts a new Bot to the database unless
 // it does not already exist
-func AddBot(path, description, tags string) (string, error) {
+// `max_retries` is the number of times a failed execution of the bot is retried
+// if the failure was caused by the infrastructure or the execution timed out
+// `requirements` are the comma separated labels a worker must advertise in
+// order to execute the bot
+// `timeout` is the maximal duration of an execution of the bot in seconds
+func AddBot(path, description, tags, requirements string,
+	max_retries, timeout int64) (string, error) {
 	// check whether bot exists already
 	err := db.QueryRow("SELECT id FROM bots WHERE name=$1", path).Scan(&path)
 	if err == nil {
 		return "", errors.New("Bot already exists!")
 	}
 
-	// escape tags
-	single_tags := strings.Split(tags, ",")
-	var buffer bytes.Buffer
-	delim := "{"
-	for _, tag := range single_tags {
-		buffer.WriteString(delim + "\"" + strings.TrimSpace(tag) + "\"")
-		delim = ","
-	}
-	buffer.WriteString("}")
-
 	// create bot
 	var result string
-	if err := db.QueryRow("INSERT INTO bots (name, description, tags, fs_path)"+
-		" VALUES ($1, $2, $3, $4) RETURNING id", path, description,
-		buffer.String(), path).
+	if err := db.QueryRow("INSERT INTO bots (name, description, tags, fs_path,"+
+		" max_retries, requirements, timeout) "+
+		"VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id", path, description,
+		makeArray(strings.Split(tags, ",")), path, max_retries,
+		makeArray(strings.Split(requirements, ",")), timeout).
 		Scan(&result); err != nil && err != sql.ErrNoRows {
 		return "", err
 	}
@@ -595,7 +737,8 @@ func AddBot(path, description, tags string) (string, error) {
 func GetBots() ([]*Bot, error) {
 	//declarations
 	var bots []*Bot
-	rows, err := db.Query("SELECT * FROM bots ORDER BY id")
+	rows, err := db.Query("SELECT id, name, description, tags, fs_path, " +
+		"max_retries, requirements, timeout FROM bots ORDER BY id")
 	if err != nil {
 		return nil, err
 	}
@@ -604,20 +747,19 @@ func GetBots() ([]*Bot, error) {
 	defer rows.Close()
 	for rows.Next() {
 		bot := Bot{}
-		var description, tags, fs_path sql.NullString
+		var description, tags, fs_path, requirements sql.NullString
 
 		if err := rows.Scan(&bot.Id, &bot.Name, &description, &tags,
-			&fs_path); err != nil {
+			&fs_path, &bot.Max_retries, &requirements,
+			&bot.Timeout); err != nil {
 			return nil, err
 		}
 
 		if description.Valid {
 			bot.Description = description.String
 		}
-		if tags.Valid {
-			bot.Tags = strings.Split(strings.Replace(
-				tags.String[1:len(tags.String)-1], "\"", "", -1), ",")
-		}
+		bot.Tags = parseArray(tags)
+		bot.Requirements = parseArray(requirements)
 		if fs_path.Valid {
 			bot.Fs_path = fs_path.String
 		}
@@ -632,10 +774,12 @@ func GetBots() ([]*Bot, error) {
 func GetBot(bid string) (*Bot, error) {
 	// declarations
 	bot := Bot{}
-	var description, tags, fs_path sql.NullString
+	var description, tags, fs_path, requirements sql.NullString
 
-	err := db.QueryRow("SELECT * FROM bots WHERE id=$1", bid).
-		Scan(&bot.Id, &bot.Name, &description, &tags, &fs_path)
+	err := db.QueryRow("SELECT id, name, description, tags, fs_path, "+
+		"max_retries, requirements, timeout FROM bots WHERE id=$1", bid).
+		Scan(&bot.Id, &bot.Name, &description, &tags, &fs_path,
+		&bot.Max_retries, &requirements, &bot.Timeout)
 	if err != nil {
 		return nil, err
 	}
@@ -643,10 +787,8 @@ func GetBot(bid string) (*Bot, error) {
 	if description.Valid {
 		bot.Description = description.String
 	}
-	if tags.Valid {
-		bot.Tags = strings.Split(strings.Replace(
-			tags.String[1:len(tags.String)-1], "\"", "", -1), ",")
-	}
+	bot.Tags = parseArray(tags)
+	bot.Requirements = parseArray(requirements)
 	if fs_path.Valid {
 		bot.Fs_path = fs_path.String
 	}
@@ -658,10 +800,42 @@ func GetBot(bid string) (*Bot, error) {
 // Workers
 //
 
+// Columns of the "workers" relation in the order expected by `scanWorker`
+const worker_columns = "workers.id, workers.uid, workers.token, " +
+	"workers.name, workers.last_contact, workers.active, workers.shared, " +
+	"workers.labels, workers.cert_fingerprint, workers.draining, " +
+	"workers.delete_after_drain, workers.capacity, " +
+	"(SELECT count(*) FROM task_leases AS occupied " +
+	"WHERE occupied.wid = workers.id)"
+
+// This function reads a worker from a row that was selected using
+// `worker_columns`
+func scanWorker(row interface {
+	Scan(...interface{}) error
+}) (*Worker, error) {
+	worker := Worker{}
+	var labels, fingerprint sql.NullString
+
+	if err := row.Scan(&worker.Id, &worker.Uid, &worker.Token, &worker.Name,
+		&worker.Last_contact, &worker.Active, &worker.Shared, &labels,
+		&fingerprint, &worker.Draining, &worker.Delete_after_drain,
+		&worker.Capacity, &worker.Occupied); err != nil {
+		return nil, err
+	}
+	worker.Labels = parseArray(labels)
+	worker.Cert_fingerprint = fingerprint.String
+
+	return &worker, nil
+}
+
 // Creates a new worker for the given user (identified by the provided
 // `user_token`). Returns the identification token for the new worker or an
-// error if the user is not privileged to created shared workers.
-func CreateWorker(user_token, name string, shared bool) (string, error) {
+// error if the user is not privileged to created shared workers. The worker
+// advertises the given `labels` (see `Worker.Satisfies`). If `cert_fingerprint`
+// is not empty the worker is bound to the client certificate with this
+// fingerprint. The worker executes up to `capacity` tasks concurrently.
+func CreateWorker(user_token, name string, shared bool, labels []string,
+	cert_fingerprint string, capacity int64) (string, error) {
 	// declarations
 	var uid int64
 	var admin bool
@@ -683,36 +857,136 @@ func CreateWorker(user_token, name string, shared bool) (string, error) {
 	// create worker
 	token := nonExistingRandString(Token_length,
 		"SELECT 42 FROM workers WHERE token = $1")
-	db.QueryRow("INSERT INTO workers (uid, token, name, last_contact, active, "+
-		"shared) VALUES ($1, $2, $3, now(), $4, $5)", uid, token, name, false,
-		shared).Scan(&dummy)
+	if err := db.QueryRow("INSERT INTO workers (uid, token, name, "+
+		"last_contact, active, shared, labels, cert_fingerprint, capacity) "+
+		"VALUES ($1, $2, $3, now(), $4, $5, $6, NULLIF($7, ''), $8) "+
+		"RETURNING id", uid, token, name, false, shared, makeArray(labels),
+		cert_fingerprint, capacity).Scan(&dummy); err != nil {
+		return "", err
+	}
 
 	return token, nil
 }
 
 // Sets the given worker active, i.e. the `active` flag is set and the
-// `last_contact` time is updated. If the worker does not exist an error is
+// `last_contact` time is updated. A new connection of the worker is recorded
+// unless it is connected already. If the worker does not exist an error is
 // returned.
 func SetWorkerActive(token string) error {
 	var dummy string
-	return db.QueryRow("UPDATE workers SET active=true, last_contact=now() "+
-		"WHERE token=$1 RETURNING 42", token).Scan(&dummy)
+	return db.QueryRow("WITH w AS ( "+
+		"UPDATE workers SET active=true, last_contact=now() "+
+		"WHERE token=$1 RETURNING id "+
+		"), c AS ( "+
+		"INSERT INTO worker_connections (wid, connected) "+
+		"SELECT w.id, now() FROM w WHERE NOT EXISTS ("+
+		"SELECT 1 FROM worker_connections "+
+		"WHERE worker_connections.wid = w.id AND disconnected IS NULL) "+
+		") SELECT 42 FROM w", token).Scan(&dummy)
+}
+
+// Replaces the labels advertised by the given worker. If the worker does not
+// exist an error is returned.
+func SetWorkerLabels(token string, labels []string) error {
+	var dummy string
+	return db.QueryRow("UPDATE workers SET labels = $1 WHERE token = $2 "+
+		"RETURNING 42", makeArray(labels), token).
+		Scan(&dummy)
+}
+
+// Binds the given worker to the client certificate with the given fingerprint
+// unless it is already bound to a certificate. If the worker does not exist an
+// error is returned.
+func BindWorkerCertificate(token, cert_fingerprint string) error {
+	var dummy string
+	return db.QueryRow("UPDATE workers SET cert_fingerprint = "+
+		"COALESCE(cert_fingerprint, $1) WHERE token = $2 RETURNING 42",
+		cert_fingerprint, token).Scan(&dummy)
+}
+
+// Removes the binding of the user's worker to its client certificate. The
+// worker is bound to the certificate it presents next (e.g. after the
+// certificate was renewed).
+func ResetWorkerCertificate(user_token, worker_token string) error {
+	var dummy string
+	return db.QueryRow("UPDATE workers SET cert_fingerprint = NULL "+
+		"WHERE token = $1 AND uid = (SELECT id FROM users WHERE token = $2) "+
+		"RETURNING 42", worker_token, user_token).Scan(&dummy)
+}
+
+// Sets or clears the draining state of the user's worker. A draining worker
+// finishes its current task but is not assigned new tasks. If
+// `delete_after_drain` is set the worker is deleted as soon as it is idle (see
+// `DeleteDrainedWorker`). Returns the updated worker.
+func SetWorkerDraining(user_token, worker_token string, draining,
+	delete_after_drain bool) (*Worker, error) {
+	return scanWorker(db.QueryRow("UPDATE workers SET draining = $1, "+
+		"delete_after_drain = $2 WHERE token = $3 "+
+		"AND uid = (SELECT id FROM users WHERE token = $4) "+
+		"RETURNING "+worker_columns, draining, delete_after_drain && draining,
+		worker_token, user_token))
+}
+
+// Returns the number of leases the worker holds, i.e. the number of tasks it
+// executes.
+func CountWorkerLeases(wid int64) (int64, error) {
+	var count int64
+	err := db.QueryRow("SELECT count(*) FROM task_leases WHERE wid = $1",
+		wid).Scan(&count)
+	return count, err
+}
+
+// Returns whether the worker is draining, i.e. must not get new tasks.
+func IsWorkerDraining(wid int64) bool {
+	var draining bool
+	err := db.QueryRow("SELECT draining FROM workers WHERE id = $1", wid).
+		Scan(&draining)
+	return err == nil && draining
+}
+
+// Deletes the given worker if it is marked for deletion after draining and
+// does not execute a task (any longer). Returns whether it was deleted.
+func DeleteDrainedWorker(worker_token string) bool {
+	var dummy string
+	err := db.QueryRow("DELETE FROM workers WHERE token = $1 "+
+		"AND draining AND delete_after_drain AND NOT EXISTS ("+
+		"SELECT 1 FROM task_leases WHERE task_leases.wid = workers.id"+
+		") RETURNING id", worker_token).Scan(&dummy)
+	return err == nil
 }
 
 // Sets the given worker inactive, i.e. the `active` flag is unset and the
-// `last_contact` time is updated. If the worker does not exist an error is
-// returned.
+// `last_contact` time is updated. The worker's connection is recorded as
+// closed. If the worker does not exist an error is returned.
 func SetWorkerInactive(token string) error {
 	var dummy string
-	return db.QueryRow("UPDATE workers SET active=false, last_contact=now() "+
-		"WHERE token=$1 RETURNING 42", token).Scan(&dummy)
+	return db.QueryRow("WITH w AS ( "+
+		"UPDATE workers SET active=false, last_contact=now() "+
+		"WHERE token=$1 RETURNING id "+
+		"), c AS ( "+
+		"UPDATE worker_connections SET disconnected = now() FROM w "+
+		"WHERE worker_connections.wid = w.id AND disconnected IS NULL "+
+		") SELECT 42 FROM w", token).Scan(&dummy)
+}
+
+// This function marks the worker inactive unless it contacted the platform
+// within the last `seconds` seconds (e.g. because it reconnected after a
+// restart). The worker's connection is recorded as closed at its last contact.
+func SetSilentWorkerInactive(wid, seconds int64) {
+	var dummy string
+	db.QueryRow("WITH w AS ( "+
+		"UPDATE workers SET active = false WHERE id = $1 "+
+		"AND last_contact < now() - $2 * interval '1 second' "+
+		"RETURNING id, last_contact "+
+		") UPDATE worker_connections SET disconnected = w.last_contact "+
+		"FROM w WHERE worker_connections.wid = w.id "+
+		"AND disconnected IS NULL", wid, seconds).Scan(&dummy)
 }
 
 // Returns the worker that corresponds to the given token. In case the token is
 // invalid an error is returned.
 func GetWorker(token string) (*Worker, error) {
 	// declarations
-	worker := Worker{}
 	var dummy string
 
 	// update last contact
@@ -720,21 +994,60 @@ func GetWorker(token string) (*Worker, error) {
 		token).Scan(&dummy)
 
 	// get worker
-	if err := db.QueryRow("SELECT * FROM workers WHERE token = $1", token).
-		Scan(&worker.Id, &worker.Uid, &worker.Token, &worker.Name,
-		&worker.Last_contact, &worker.Active, &worker.Shared); err != nil {
-		return nil, err
-	}
+	return scanWorker(db.QueryRow("SELECT "+worker_columns+" FROM workers "+
+		"WHERE token = $1", token))
+}
 
-	return &worker, nil
+// Returns the worker with the given id. Unlike `GetWorker` the worker's last
+// contact is not updated.
+func GetWorkerById(wid string) (*Worker, error) {
+	return scanWorker(db.QueryRow("SELECT "+worker_columns+" FROM workers "+
+		"WHERE id = $1", wid))
 }
 
 // Retrieves all of the user's workers.
 func GetWorkers(token string) ([]*Worker, error) {
+	return queryWorkers("SELECT "+worker_columns+" FROM workers "+
+		"WHERE uid = (SELECT id FROM users WHERE token = $1)", token)
+}
+
+// Retrieves the workers that can execute the user's tasks, i.e. the user's own
+// workers and the shared workers of all users.
+func GetUsableWorkers(token string) ([]*Worker, error) {
+	return queryWorkers("SELECT "+worker_columns+" FROM workers "+
+		"WHERE shared OR uid = (SELECT id FROM users WHERE token = $1) "+
+		"ORDER BY id", token)
+}
+
+// Retrieves the shared workers of all users along with the statistics of
+// their executions.
+func GetSharedWorkerSummaries() ([]*Worker_summary, error) {
+	workers, err := queryWorkers("SELECT " + worker_columns + " FROM workers " +
+		"WHERE shared ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+
+	var summaries []*Worker_summary
+	for _, worker := range workers {
+		stats, err := GetWorkerStatistics(worker.Id)
+		if err != nil {
+			return nil, err
+		}
+		summaries = append(summaries, &Worker_summary{
+			Worker:     worker,
+			Statistics: stats,
+		})
+	}
+
+	return summaries, nil
+}
+
+// Helper to fetch the workers selected by the given query.
+func queryWorkers(query string, args ...interface{}) ([]*Worker, error) {
 	// declarations
 	var workers []*Worker
-	rows, err := db.Query("SELECT * FROM workers "+
-		"WHERE uid = (SELECT id FROM users WHERE token = $1)", token)
+	rows, err := db.Query(query, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -742,36 +1055,115 @@ func GetWorkers(token string) ([]*Worker, error) {
 	// fetch workers
 	defer rows.Close()
 	for rows.Next() {
-		worker := Worker{}
-
-		err := rows.Scan(&worker.Id, &worker.Uid, &worker.Token, &worker.Name,
-			&worker.Last_contact, &worker.Active, &worker.Shared)
+		worker, err := scanWorker(rows)
 		if err != nil {
 			return nil, err
 		}
 
-		workers = append(workers, &worker)
+		workers = append(workers, worker)
 	}
 
 	return workers, nil
 }
 
+// Fetch the statistics of the worker's executions from the database. The
+// outcome of an execution is the status of its task.
+func GetWorkerStatistics(wid int64) (*Worker_statistics, error) {
+	// declarations
+	stats := Worker_statistics{}
+	var average_duration sql.NullFloat64
+
+	// fetch statistics
+	if err := db.QueryRow("SELECT count(*), "+
+		"count(*) FILTER (WHERE tasks.status = $2), "+
+		"count(*) FILTER (WHERE tasks.status = $3), "+
+		"count(*) FILTER (WHERE tasks.status = $4), "+
+		"count(*) FILTER (WHERE tasks.status = $5), "+
+		"avg(extract(epoch FROM tasks.end_time - tasks.start_time)) "+
+		"FILTER (WHERE tasks.status IN ($2, $3)) "+
+		"FROM executions INNER JOIN tasks ON executions.tid = tasks.id "+
+		"WHERE executions.wid = $1", wid, Succeeded, Failed, Canceled,
+		TimedOut).Scan(&stats.Executions, &stats.Succeeded, &stats.Failed,
+		&stats.Canceled, &stats.Timed_out, &average_duration); err != nil {
+		return nil, err
+	}
+
+	if average_duration.Valid {
+		stats.Average_duration = average_duration.Float64
+	}
+
+	return &stats, nil
+}
+
+// Retrieves the latest `size` connections of the worker.
+func GetWorkerConnections(wid int64, size int) ([]*Worker_connection,
+	error) {
+	// declarations
+	var connections []*Worker_connection
+	rows, err := db.Query("SELECT connected, disconnected "+
+		"FROM worker_connections WHERE wid = $1 "+
+		"ORDER BY connected DESC, id DESC LIMIT $2", wid, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch connections
+	defer rows.Close()
+	for rows.Next() {
+		connection := Worker_connection{}
+		var disconnected pq.NullTime
+
+		if err := rows.Scan(&connection.Connected,
+			&disconnected); err != nil {
+			return nil, err
+		}
+		if disconnected.Valid {
+			connection.Disconnected = &disconnected.Time
+		}
+
+		connections = append(connections, &connection)
+	}
+
+	return connections, nil
+}
+
 // Delete the given worker for the given user from the database.
 func DeleteWorker(user_token, worker_token string) error {
-	//declarations
-	worker := Worker{}
-
-	if err := db.QueryRow("DELETE FROM workers WHERE token = $1 "+
-		"AND uid = (SELECT id FROM users WHERE token = $2) RETURNING *",
-		worker_token, user_token).Scan(&worker.Id, &worker.Uid, &worker.Token,
-		&worker.Name, &worker.Last_contact, &worker.Active,
-		&worker.Shared); err != nil {
+	if _, err := scanWorker(db.QueryRow("DELETE FROM workers "+
+		"WHERE token = $1 AND uid = (SELECT id FROM users WHERE token = $2) "+
+		"RETURNING "+worker_columns, worker_token, user_token)); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// This function returns the ids of the tasks the user's worker holds a lease
+// on, i.e. the tasks it executes.
+func GetWorkerTasks(user_token, worker_token string) ([]int64, error) {
+	var tid int64
+	var tasks []int64
+
+	rows, err := db.Query("SELECT task_leases.tid FROM task_leases "+
+		"INNER JOIN workers ON task_leases.wid = workers.id "+
+		"INNER JOIN users ON workers.uid = users.id "+
+		"WHERE workers.token = $1 AND users.token = $2 "+
+		"ORDER BY task_leases.tid", worker_token, user_token)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := rows.Scan(&tid); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, tid)
+	}
+
+	return tasks, nil
+}
+
 //
 // Tasks
 //
@@ -814,7 +1206,8 @@ func getGroupTask(gid int64) (*group_task, error) {
 	var uid, pid, bid int64
 	user := User{}
 
-	if err := db.QueryRow("SELECT * FROM group_tasks WHERE id = $1", gid).
+	if err := db.QueryRow("SELECT id, uid, pid, bid FROM group_tasks "+
+		"WHERE id = $1", gid).
 		Scan(&gt.id, &uid, &pid, &bid); err != nil {
 		return nil, err
 	}
@@ -825,7 +1218,8 @@ func getGroupTask(gid int64) (*group_task, error) {
 	// fetch user
 	if err := db.QueryRow("SELECT * FROM users WHERE id=$1", uid).
 		Scan(&user.Id, &user.GH_Id, &user_name, &real_name, &email,
-		&user.Token, &user.Worker_token, &user.Admin); err != nil {
+		&user.Token, &user.Worker_token, &user.Admin,
+		&user.Share_weight); err != nil {
 		return nil, err
 	}
 
@@ -847,20 +1241,103 @@ func getGroupTask(gid int64) (*group_task, error) {
 	return &gt, nil
 }
 
-// This function retrieves the information for a task specified by his id
-// and the users' token and creates a *Task from these values
-func GetTask(tid, user_token string) (*Task, error) {
+// Columns selecting the routing rules of a task group and of its user's
+// project membership (see `EffectiveRoutingRule`). The "members" relation must
+// be joined using `routing_join`.
+const routing_columns = "group_tasks.routing, group_tasks.allowed_workers, " +
+	"members.routing, members.allowed_workers"
+
+// Join of the project membership of a task group's user
+const routing_join = "LEFT JOIN members ON members.uid = group_tasks.uid " +
+	"AND members.pid = group_tasks.pid"
+
+// This function returns the routing rule stored in the given columns or nil if
+// there is none
+func makeRoutingRule(policy sql.NullInt64,
+	workers sql.NullString) *RoutingRule {
+	if !policy.Valid {
+		return nil
+	}
+
+	return &RoutingRule{Policy: policy.Int64, Workers: parseIdArray(workers)}
+}
+
+// This function returns the routing rule in effect for the tasks of the task
+// group
+func getGroupRoutingRule(gid int64) (*RoutingRule, error) {
+	var group_routing, project_routing sql.NullInt64
+	var group_workers, project_workers sql.NullString
+
+	if err := db.QueryRow("SELECT "+routing_columns+" FROM group_tasks "+
+		routing_join+" WHERE group_tasks.id = $1", gid).
+		Scan(&group_routing, &group_workers, &project_routing,
+		&project_workers); err != nil {
+		return nil, err
+	}
+
+	return EffectiveRoutingRule(makeRoutingRule(group_routing, group_workers),
+		makeRoutingRule(project_routing, project_workers)), nil
+}
+
+// Columns of a task along with its user, project, bot, worker and routing
+// rule in the order expected by `scanTask`. The relations must be joined using
+// `task_joins`.
+const task_columns = "tasks.id, tasks.gid, tasks.start_time, " +
+	"tasks.end_time, tasks.status, tasks.exit_status, tasks.stdout, " +
+	"tasks.stderr, tasks.stdout_truncated, tasks.stderr_truncated, " +
+	"tasks.metadata, tasks.failure_reason, tasks.patch, tasks.parent, " +
+	"tasks.attempt, tasks.infra_failure, tasks.not_before, " +
+	"tasks.priority + group_tasks.priority, tasks.deadline, " +
+	"tasks.cancel_reason, tasks.canceled_by, tasks.wid, workers.name, " +
+	"tasks.worker_hostname, tasks.catch_up_time, users.id, users.gh_id, " +
+	"users.username, users.realname, users.email, users.token, " +
+	"users.worker_token, users.admin, users.share_weight, projects.id, " +
+	"projects.gh_id, projects.name, projects.clone_url, projects.fs_path, " +
+	"bots.id, bots.name, bots.description, bots.tags, bots.fs_path, " +
+	"bots.max_retries, bots.requirements, bots.timeout, " + routing_columns
+
+// Joins of the relations selected by `task_columns`
+const task_joins = "INNER JOIN group_tasks ON tasks.gid = group_tasks.id " +
+	"INNER JOIN users ON group_tasks.uid = users.id " +
+	"INNER JOIN projects ON group_tasks.pid = projects.id " +
+	"INNER JOIN bots ON group_tasks.bid = bots.id " +
+	"LEFT JOIN workers ON tasks.wid = workers.id " + routing_join
+
+// This function reads a task from a row that was selected using
+// `task_columns`. Columns selected after them are stored in `extra`.
+func scanTask(row interface {
+	Scan(...interface{}) error
+}, extra ...interface{}) (*Task, error) {
 	// declarations
-	var start_time, end_time pq.NullTime
-	var exit_status sql.NullInt64
-	var output sql.NullString
+	var start_time, end_time, not_before, deadline pq.NullTime
+	var catch_up_time pq.NullTime
+	var exit_status, parent sql.NullInt64
+	var stdout, stderr, metadata, failure_reason sql.NullString
+	var cancel_reason, canceled_by sql.NullString
+	var wid sql.NullInt64
+	var worker_name, worker_hostname sql.NullString
+	var user_name, real_name, email sql.NullString
+	var admin sql.NullBool
+	var project_name, clone_url, project_path sql.NullString
+	var description, tags, bot_path, requirements sql.NullString
+	var group_routing, project_routing sql.NullInt64
+	var group_workers, project_workers sql.NullString
 
 	// initialize Task
-	task := Task{}
-	// get task information
-	if err := db.QueryRow("SELECT * FROM tasks WHERE tasks.id=$1", tid).
-		Scan(&task.Id, &task.Gid, &start_time, &end_time, &task.Status,
-		&exit_status, &output, &task.Patch); err != nil {
+	task := Task{User: &User{}, Project: &Project{}, Bot: &Bot{}}
+	columns := []interface{}{&task.Id, &task.Gid, &start_time, &end_time,
+		&task.Status, &exit_status, &stdout, &stderr, &task.Stdout_truncated,
+		&task.Stderr_truncated, &metadata, &failure_reason, &task.Patch,
+		&parent, &task.Attempt, &task.Infra_failure, &not_before,
+		&task.Priority, &deadline, &cancel_reason, &canceled_by, &wid,
+		&worker_name, &worker_hostname, &catch_up_time, &task.User.Id,
+		&task.User.GH_Id, &user_name, &real_name, &email, &task.User.Token,
+		&task.User.Worker_token, &admin, &task.User.Share_weight,
+		&task.Project.Id, &task.Project.GH_Id, &project_name, &clone_url,
+		&project_path, &task.Bot.Id, &task.Bot.Name, &description, &tags,
+		&bot_path, &task.Bot.Max_retries, &requirements, &task.Bot.Timeout,
+		&group_routing, &group_workers, &project_routing, &project_workers}
+	if err := row.Scan(append(columns, extra...)...); err != nil {
 		return nil, err
 	}
 	// set remaining fields
@@ -873,27 +1350,138 @@ func GetTask(tid, user_token string) (*Task, error) {
 	if exit_status.Valid {
 		task.Exit_status = exit_status.Int64
 	}
-	if output.Valid {
-		task.Output = output.String
+	if stdout.Valid {
+		task.Stdout = stdout.String
 	}
-
-	group_task, _ := getGroupTask(task.Gid)
-	task.User = group_task.user
-	task.Project = group_task.project
-	task.Bot = group_task.bot
+	if stderr.Valid {
+		task.Stderr = stderr.String
+	}
+	if metadata.Valid {
+		if err := json.Unmarshal([]byte(metadata.String),
+			&task.Metadata); err != nil {
+			return nil, err
+		}
+	}
+	if failure_reason.Valid {
+		task.Failure_reason = failure_reason.String
+	}
+	if parent.Valid {
+		task.Parent = parent.Int64
+	}
+	if not_before.Valid {
+		task.Not_before = &not_before.Time
+	}
+	if deadline.Valid {
+		task.Deadline = &deadline.Time
+	}
+	if cancel_reason.Valid {
+		task.Cancel_reason = cancel_reason.String
+	}
+	if canceled_by.Valid {
+		task.Canceled_by = canceled_by.String
+	}
+	if wid.Valid {
+		task.Wid = wid.Int64
+	}
+	if worker_name.Valid {
+		task.Worker_name = worker_name.String
+	}
+	if worker_hostname.Valid {
+		task.Worker_hostname = worker_hostname.String
+	}
+	if catch_up_time.Valid {
+		task.Catch_up_time = &catch_up_time.Time
+	}
+	task.User.User_name = user_name.String
+	task.User.Real_name = real_name.String
+	task.User.Email = email.String
+	task.User.Admin = admin.Bool
+	task.Project.Name = project_name.String
+	task.Project.Clone_url = clone_url.String
+	task.Project.Fs_path = project_path.String
+	task.Bot.Description = description.String
+	task.Bot.Tags = parseArray(tags)
+	task.Bot.Fs_path = bot_path.String
+	task.Bot.Requirements = parseArray(requirements)
+	task.Routing = EffectiveRoutingRule(
+		makeRoutingRule(group_routing, group_workers),
+		makeRoutingRule(project_routing, project_workers))
 
 	return &task, nil
 }
 
-// This function selects a Pending Task from the user and returns it
-// If there exists no task and the shared flag is set then a random
-// pending task is been chosen and returned otherwise it will return nil
-func GetPendingTask(uid int64, shared bool) (*Task, error) {
+// This function retrieves the information for a task specified by his id
+// and the users' token and creates a *Task from these values. Fails if the task
+// belongs to another user.
+func GetTask(tid, user_token string) (*Task, error) {
+	return scanTask(db.QueryRow("SELECT "+task_columns+" FROM tasks "+
+		task_joins+" WHERE tasks.id = $1 AND users.token = $2", tid,
+		user_token))
+}
+
+// Joins needed to order the pending tasks (see `pending_task_order`). The
+// occupancy counts the shared workers executing a task of each user.
+const pending_task_joins = "INNER JOIN group_tasks " +
+	"ON tasks.gid = group_tasks.id " +
+	"INNER JOIN users ON group_tasks.uid = users.id " +
+	"LEFT JOIN (SELECT group_tasks.uid, count(*) AS occupied " +
+	"FROM task_leases " +
+	"INNER JOIN workers ON task_leases.wid = workers.id " +
+	"INNER JOIN tasks ON task_leases.tid = tasks.id " +
+	"INNER JOIN group_tasks ON tasks.gid = group_tasks.id " +
+	"WHERE workers.shared GROUP BY group_tasks.uid) AS occupancy " +
+	"ON occupancy.uid = users.id"
+
+// Condition excluding the pending tasks that wait until the running execution
+// of their group finished (see `Overlap_queue_one`). Requires the join of
+// `group_tasks`.
+var held_task_condition = fmt.Sprintf("NOT (group_tasks.overlap = %d "+
+	"AND EXISTS (SELECT 42 FROM tasks AS active "+
+	"WHERE active.gid = tasks.gid AND active.status IN (%d, %d)))",
+	Overlap_queue_one, Scheduled, Running)
+
+// Condition selecting the pending tasks that may be assigned right away, $1
+// refers to the status `Pending`. Requires the join of `group_tasks`.
+var pending_task_condition = "tasks.status = $1 " +
+	"AND (tasks.not_before IS NULL OR tasks.not_before <= now()) " +
+	"AND " + held_task_condition
+
+// Order in which pending tasks are assigned to workers
+const pending_task_order = "tasks.priority + group_tasks.priority DESC, " +
+	"COALESCE(occupancy.occupied, 0)::float / users.share_weight ASC, " +
+	"tasks.id ASC"
+
+// This function selects a Pending Task that the given worker is able to
+// execute (see `Worker.Satisfies`) and is permitted to execute by the task's
+// routing rule (see `RoutingRule.Permits`) and returns it
+// Tasks of the worker's owner are preferred. If there exists no such task and
+// the worker is shared then any other matching pending task is been chosen and
+// returned otherwise it will return nil
+// Tasks with a higher priority are chosen first. Among tasks of equal priority
+// the users share the shared workers according to their `share_weight`, i.e.
+// the task of the user occupying the fewest shared workers relative to the
+// weight is chosen. Remaining ties are broken by the age of the task.
+func GetPendingTask(worker *Worker) (*Task, error) {
+	task, err := getMatchingPendingTask(worker, "group_tasks.uid = $2")
+	if task != nil || err != nil || !worker.Shared {
+		return task, err
+	}
+
+	return getMatchingPendingTask(worker, "group_tasks.uid <> $2")
+}
+
+// This function returns the first pending task that satisfies `condition`
+// (where $2 refers to the worker's owner), whose bot's requirements are met by
+// the given worker and whose routing rule permits the worker. If there is no
+// such task nil is returned.
+func getMatchingPendingTask(worker *Worker, condition string) (*Task, error) {
 	//declarations
-	rows, err := db.Query("SELECT tasks.id, users.token FROM tasks "+
-		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
-		"INNER JOIN users ON group_tasks.uid = users.id "+
-		"WHERE group_tasks.uid = $1 AND tasks.status = $2", uid, Pending)
+	rows, err := db.Query("SELECT tasks.id, users.id, users.token, "+
+		"bots.requirements, "+routing_columns+" FROM tasks "+
+		pending_task_joins+" "+
+		"INNER JOIN bots ON group_tasks.bid = bots.id "+routing_join+" "+
+		"WHERE "+pending_task_condition+" AND "+condition+" "+
+		"ORDER BY "+pending_task_order, Pending, worker.Uid)
 	if err != nil {
 		return nil, err
 	}
@@ -902,41 +1490,31 @@ func GetPendingTask(uid int64, shared bool) (*Task, error) {
 	// fetch task
 	for rows.Next() {
 		var tid, user_token string
-		if err := rows.Scan(&tid, &user_token); err != nil {
+		var uid int64
+		var requirements sql.NullString
+		var group_routing, project_routing sql.NullInt64
+		var group_workers, project_workers sql.NullString
+		if err := rows.Scan(&tid, &uid, &user_token, &requirements,
+			&group_routing, &group_workers, &project_routing,
+			&project_workers); err != nil {
 			return nil, err
 		}
 
-		task, err := GetTask(tid, user_token)
-		if err != nil {
-			return nil, err
+		if !worker.Satisfies(parseArray(requirements)) {
+			continue
+		}
+		routing := EffectiveRoutingRule(
+			makeRoutingRule(group_routing, group_workers),
+			makeRoutingRule(project_routing, project_workers))
+		if !routing.Permits(worker, uid) {
+			continue
 		}
-		return task, nil
-	}
 
-	if shared {
-		//declarations
-		rows, err := db.Query("SELECT tasks.id, users.token FROM tasks "+
-			"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
-			"INNER JOIN users ON group_tasks.uid = users.id "+
-			"WHERE tasks.status = $1", Pending)
+		task, err := GetTask(tid, user_token)
 		if err != nil {
 			return nil, err
 		}
-
-		// fetch task
-		defer rows.Close()
-		for rows.Next() {
-			var tid, user_token string
-			if err := rows.Scan(&tid, &user_token); err != nil {
-				return nil, nil
-			}
-
-			task, err := GetTask(tid, user_token)
-			if err != nil {
-				return nil, err
-			}
-			return task, nil
-		}
+		return task, nil
 	}
 
 	return nil, nil
@@ -944,7 +1522,10 @@ func GetPendingTask(uid int64, shared bool) (*Task, error) {
 
 // This function creates a new *Task in the database initialized with
 // the user, project and bot information and returns it
-func CreateNewChildTask(gtid int64) (*Task, error) {
+// If `previous` is given the new task is another attempt of the failed task
+// `previous` which must not be executed within the next `delay` seconds
+func CreateNewChildTask(gtid int64, previous *Task, delay int64) (*Task,
+	error) {
 	group_task, _ := getGroupTask(gtid)
 
 	// create task
@@ -954,56 +1535,116 @@ func CreateNewChildTask(gtid int64) (*Task, error) {
 		Project:     group_task.project,
 		Bot:         group_task.bot,
 		Exit_status: -1,
+		Attempt:     1,
+	}
+	var parent sql.NullInt64
+	// retries of a catch-up execution catch up as well
+	var catch_up_time pq.NullTime
+	if previous != nil {
+		task.Parent = previous.Id
+		if previous.Parent != 0 {
+			task.Parent = previous.Parent
+		}
+		task.Attempt = previous.Attempt + 1
+		parent.Int64, parent.Valid = task.Parent, true
+		if previous.Catch_up_time != nil {
+			task.Catch_up_time = previous.Catch_up_time
+			catch_up_time.Time, catch_up_time.Valid = *previous.Catch_up_time,
+				true
+		}
 	}
 
-	// insert into db
-	if err := db.QueryRow("INSERT INTO tasks (gid, status, patch)"+
-		" VALUES ($1, $2, '') RETURNING id", gtid, Pending).
-		Scan(&task.Id); err != nil {
+	// insert into db (the base priority depends on the kind of the task group)
+	var not_before pq.NullTime
+	if err := db.QueryRow("INSERT INTO tasks (gid, status, patch, parent, "+
+		"attempt, not_before, priority, catch_up_time) VALUES ($1, $2, '', "+
+		"$3, $4, CASE WHEN $5 > 0 THEN now() + $5 * interval '1 second' END, "+
+		"CASE WHEN EXISTS (SELECT 42 FROM instant_tasks WHERE id = $1) "+
+		"THEN $6 WHEN EXISTS (SELECT 42 FROM event_tasks WHERE id = $1) "+
+		"THEN $7 ELSE $8 END, $9) RETURNING id, not_before, priority + "+
+		"(SELECT priority FROM group_tasks WHERE id = $1)", gtid, Pending,
+		parent, task.Attempt, delay, Instant_priority, Event_priority,
+		Scheduled_priority, catch_up_time).
+		Scan(&task.Id, &not_before, &task.Priority); err != nil {
+		return nil, err
+	}
+	if not_before.Valid {
+		task.Not_before = &not_before.Time
+	}
+	routing, err := getGroupRoutingRule(gtid)
+	if err != nil {
 		return nil, err
 	}
+	task.Routing = routing
 
 	return &task, nil
 }
 
 // This function updates the tasks' status with the provided value.
-// Do not call this function with Succeeded or Failed as values for new_status.
+// Do not call this function with Succeeded, Failed, Canceled or TimedOut as
+// values for new_status.
 func UpdateTaskStatus(tid int64, new_status int64) {
 	var dummy string
 
 	if new_status == Running {
 		db.QueryRow("UPDATE tasks SET status=$1, start_time=now() WHERE id=$2",
 			new_status, tid).Scan(&dummy)
-	} else if new_status == Canceled {
-		db.QueryRow("UPDATE tasks SET status=$1, end_time=now() WHERE id=$2",
-			new_status, tid).Scan(&dummy)
 	} else {
 		db.QueryRow("UPDATE tasks SET status=$1 WHERE id=$2", new_status, tid).
 			Scan(&dummy)
 	}
 }
 
-// This function updates the tasks' result with the given output and returns a
+// This function marks the pending, scheduled or running task as canceled.
+// `reason` describes why the task was canceled and `actor` who canceled it
+// (a user name or `Platform_actor`).
+func CancelTask(tid int64, reason, actor string) {
+	var dummy string
+
+	db.QueryRow("UPDATE tasks SET status=$1, end_time=now(), "+
+		"cancel_reason=$2, canceled_by=$3 WHERE id=$4 "+
+		"AND status IN ($5, $6, $7)", Canceled, reason, actor, tid, Pending,
+		Scheduled, Running).Scan(&dummy)
+}
+
+// This function updates the tasks' result with the given result and returns a
 // non-existing file name if requested.
-func UpdateTaskResult(tid int64, output string, exit_code int,
-	gen_file_name bool) string {
+// The task is considered failed if the exit code is non-zero or the execution
+// failed because of an infrastructure problem (`Infra_failure`). Fails if the
+// task is not scheduled or running anymore (e.g. because it was canceled).
+func UpdateTaskResult(tid int64, result *TaskResult,
+	gen_file_name bool) (string, error) {
 	new_status := Succeeded
-	if exit_code != 0 {
+	if result.Exit_status != 0 || result.Infra_failure {
 		new_status = Failed
 	}
 
 	var file_name, dummy string
+	var metadata sql.NullString
+
+	if len(result.Metadata) > 0 {
+		if js, err := json.Marshal(result.Metadata); err == nil {
+			metadata.String, metadata.Valid = string(js), true
+		}
+	}
 
 	if gen_file_name {
 		file_name = nonExistingRandString(Token_length,
 			"SELECT 42 FROM tasks WHERE patch = $1 || '.patch'") + ".patch"
 	}
 
-	db.QueryRow("UPDATE tasks SET status=$1, end_time=now(), output=$2, "+
-		"exit_status=$3, patch=$4 WHERE id=$5", new_status, output, exit_code,
-		file_name, tid).Scan(&dummy)
+	if err := db.QueryRow("UPDATE tasks SET status=$1, end_time=now(), "+
+		"stdout=$2, stderr=$3, stdout_truncated=$4, stderr_truncated=$5, "+
+		"metadata=$6, exit_status=$7, patch=$8, infra_failure=$9 "+
+		"WHERE id=$10 AND status IN ($11, $12) RETURNING id", new_status,
+		result.Stdout, result.Stderr, result.Stdout_truncated,
+		result.Stderr_truncated, metadata, result.Exit_status, file_name,
+		result.Infra_failure, tid, Scheduled, Running).
+		Scan(&dummy); err != nil {
+		return "", err
+	}
 
-	return file_name
+	return file_name, nil
 }
 
 // Returns the file name for the given patch file. Fails if the user does not
@@ -1025,13 +1666,14 @@ func GetPatchFileName(token, patch_file string) (string, error) {
 
 // This function returns all tasks from the database which are related to
 // the provided group_task id as a list of *Task
-func GetChildTasks(gtid int64) ([]*Task, error) {
+// If `wid` is not 0 only the tasks executed by this worker are returned.
+func GetChildTasks(gtid, wid int64) ([]*Task, error) {
 	var tasks []*Task
 
 	rows, err := db.Query("SELECT tasks.id, users.token FROM tasks "+
 		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
 		"INNER JOIN users ON group_tasks.uid = users.id "+
-		"WHERE group_tasks.id = $1", gtid)
+		"WHERE group_tasks.id = $1 AND ($2 = 0 OR tasks.wid = $2)", gtid, wid)
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return tasks, nil
@@ -1087,57 +1729,769 @@ func GetActiveChildren(gtid int64) ([]*Task, error) {
 	return tasks, err
 }
 
-// This function returns the id's of all tasks which are running
-// longer then the maxseconds duration
-func GetTimedOverTasks(maxseconds int64) ([]int64, error) {
-	var starttime pq.NullTime
-	var tid int64
-	var tasks []int64
+// This function returns all attempts of the given task (the first execution and
+// all of its retries) ordered by the attempt number
+func GetTaskAttempts(task *Task) ([]*Task, error) {
+	var tasks []*Task
+	first := task.Id
+	if task.Parent != 0 {
+		first = task.Parent
+	}
 
-	// get all running tasks
-	rows, err := db.Query("SELECT tasks.id , tasks.start_time FROM tasks"+
-		" WHERE tasks.status=$1"+
-		" ORDER BY tasks.start_time", Running)
+	rows, err := db.Query("SELECT tasks.id, users.token FROM tasks "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
+		"INNER JOIN users ON group_tasks.uid = users.id "+
+		"WHERE tasks.id = $1 OR tasks.parent = $1 ORDER BY tasks.attempt",
+		first)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	// get starting time of running bots
 	for rows.Next() {
-		if err := rows.Scan(&tid, &starttime); err != nil {
+		var tid, user_token string
+		if err := rows.Scan(&tid, &user_token); err != nil {
 			return nil, err
 		}
-		// check if running time succeeded maximal time
-		if starttime.Valid {
-			runtime := int64(time.Since(starttime.Time).Seconds())
-			if runtime >= maxseconds {
-				// time is over - add to canceled tasks
-				tasks = append(tasks, tid)
-			}
+		task, err := GetTask(tid, user_token)
+		if err != nil {
+			return nil, err
 		}
+		tasks = append(tasks, task)
 	}
 	return tasks, nil
 }
 
-//########################################################
+// This function returns all pending tasks which must not be executed yet
+// because they are delayed retries
+func GetDelayedTasks() ([]*Task, error) {
+	var tasks []*Task
 
-// ScheduledTask
-//########################################################
+	rows, err := db.Query("SELECT tasks.id, users.token FROM tasks "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
+		"INNER JOIN users ON group_tasks.uid = users.id "+
+		"WHERE tasks.status = $1 AND tasks.not_before > now()", Pending)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
 
-// This function creates a *ScheduledTask from the given parameters,
-// makes a database entry in the table group_tasks and schedule_tasks
-// and returns it
-func CreateScheduledTask(token string, pid string, bid string, name string,
-	next time.Time, cron_exp string) (*ScheduledTask, error) {
+	for rows.Next() {
+		var tid, user_token string
+		if err := rows.Scan(&tid, &user_token); err != nil {
+			return nil, err
+		}
+		task, err := GetTask(tid, user_token)
+		if err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, task)
+	}
+	return tasks, nil
+}
+
+// This function checks whether the task is pending and does not wait for the
+// running execution of its group to finish (see `Overlap_queue_one`)
+func IsTaskAssignable(tid int64) bool {
+	var dummy string
+	if err := db.QueryRow("SELECT tasks.id FROM tasks "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
+		"WHERE tasks.id = $1 AND tasks.status = $2 AND "+held_task_condition,
+		tid, Pending).Scan(&dummy); err != nil {
+		return false
+	}
+	return true
+}
+
+// This function returns the oldest execution of the task group that waited
+// for the running execution to finish (see `Overlap_queue_one`) and may be
+// assigned now, or nil if there is no such execution.
+func GetQueuedTask(gid int64) (*Task, error) {
+	var tid int64
+	if err := db.QueryRow("SELECT tasks.id FROM tasks "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
+		"WHERE tasks.gid = $2 AND group_tasks.overlap = $3 AND "+
+		pending_task_condition+" ORDER BY tasks.id LIMIT 1", Pending, gid,
+		Overlap_queue_one).Scan(&tid); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	return GetTaskById(tid)
+}
+
+// This function returns the overlap policy of the task group (see
+// `TaskGroupSettings.Overlap`).
+func GetOverlapPolicy(gid int64) (int64, error) {
+	var overlap int64
+	if err := db.QueryRow("SELECT overlap FROM group_tasks WHERE id = $1",
+		gid).Scan(&overlap); err != nil {
+		return 0, err
+	}
+
+	return overlap, nil
+}
+
+// This function marks the scheduled or running task as failed because of an
+// infrastructure problem (e.g. because the worker executing it stopped
+// responding) and drops the worker's lease. The `reason` is stored as the
+// task's failure reason. Returns the failed task.
+func FailOrphanedTask(tid int64, reason string) (*Task, error) {
+	var dummy string
+
+	if err := db.QueryRow("WITH l AS ( "+
+		"DELETE FROM task_leases WHERE tid = $1 "+
+		") "+
+		"UPDATE tasks SET status = $2, end_time = now(), "+
+		"infra_failure = true, failure_reason = $3 WHERE id = $1 "+
+		"AND status IN ($4, $5) RETURNING id", tid, Failed, reason,
+		Scheduled, Running).Scan(&dummy); err != nil {
+		return nil, err
+	}
+
+	return GetTaskById(tid)
+}
+
+// This function returns the task specified by its id on behalf of the user who
+// created it
+func GetTaskById(tid int64) (*Task, error) {
+	var user_token string
+
+	if err := db.QueryRow("SELECT users.token FROM tasks "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
+		"INNER JOIN users ON group_tasks.uid = users.id "+
+		"WHERE tasks.id = $1", tid).Scan(&user_token); err != nil {
+		return nil, err
+	}
+
+	return GetTask(strconv.FormatInt(tid, 10), user_token)
+}
+
+// This function creates a new clone token for the task which replaces the
+// previous one (if any). The token allows cloning the task's project while the
+// task is scheduled or running (see `GetCloneTask`).
+func CreateCloneToken(tid int64) (string, error) {
+	var dummy string
+
+	clone_token := nonExistingRandString(Token_length,
+		"SELECT 42 FROM tasks WHERE clone_token = $1")
+	if err := db.QueryRow("UPDATE tasks SET clone_token = $1 WHERE id = $2 "+
+		"RETURNING id", clone_token, tid).Scan(&dummy); err != nil {
+		return "", err
+	}
+
+	return clone_token, nil
+}
+
+// This function returns the task whose project may be cloned using the given
+// clone token. Fails if the token does not belong to the task or the task is
+// neither scheduled nor running (any longer).
+func GetCloneTask(tid int64, clone_token string) (*Task, error) {
+	var dummy string
+
+	if err := db.QueryRow("SELECT id FROM tasks WHERE id = $1 "+
+		"AND clone_token = $2 AND status IN ($3, $4)", tid, clone_token,
+		Scheduled, Running).Scan(&dummy); err != nil {
+		return nil, err
+	}
+
+	return GetTaskById(tid)
+}
+
+// This function returns the id's of all scheduled or running tasks whose
+// deadline passed (see `SetTaskDeadline`)
+func GetTimedOverTasks() ([]int64, error) {
+	var tid int64
+	var tasks []int64
+
+	rows, err := db.Query("SELECT id FROM tasks "+
+		"WHERE status IN ($1, $2) AND deadline < now() ORDER BY deadline",
+		Scheduled, Running)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := rows.Scan(&tid); err != nil {
+			return nil, err
+		}
+		tasks = append(tasks, tid)
+	}
+
+	return tasks, nil
+}
+
+// This function sets the deadline of the task to `seconds` seconds from now.
+// The task times out unless its result is published before. The deadline is
+// returned.
+func SetTaskDeadline(tid, seconds int64) (time.Time, error) {
+	var deadline time.Time
+
+	if err := db.QueryRow("UPDATE tasks "+
+		"SET deadline = now() + $2 * interval '1 second' WHERE id = $1 "+
+		"RETURNING deadline", tid, seconds).Scan(&deadline); err != nil {
+		return time.Time{}, err
+	}
+
+	return deadline, nil
+}
+
+// This function records the worker the task was assigned to. The host name of
+// a previous assignment is cleared.
+func SetTaskWorker(tid, wid int64) error {
+	var dummy string
+
+	return db.QueryRow("UPDATE tasks SET wid = $2, worker_hostname = NULL "+
+		"WHERE id = $1 RETURNING id", tid, wid).Scan(&dummy)
+}
+
+// This function records the host name reported by the worker executing the
+// task. Overlong host names are shortened.
+func SetTaskWorkerHostname(tid int64, hostname string) error {
+	var dummy string
+
+	return db.QueryRow("UPDATE tasks SET worker_hostname = left($2, 255) "+
+		"WHERE id = $1 RETURNING id", tid, hostname).Scan(&dummy)
+}
+
+// This function marks the task as catching up the execution that was due at
+// `due` but was missed while the platform was down.
+func SetTaskCatchUp(tid int64, due time.Time) error {
+	var dummy string
+
+	return db.QueryRow("UPDATE tasks SET catch_up_time = $2 WHERE id = $1 "+
+		"RETURNING id", tid, due.UTC()).Scan(&dummy)
+}
+
+// This function marks the scheduled or running task as timed out and drops
+// the worker's lease. The `reason` is stored as the task's cancellation reason
+// and the platform as the actor. Returns the timed out task.
+func TimeOutTask(tid int64, reason string) (*Task, error) {
+	var dummy string
+
+	if err := db.QueryRow("WITH l AS ( "+
+		"DELETE FROM task_leases WHERE tid = $1 "+
+		") "+
+		"UPDATE tasks SET status = $2, end_time = now(), "+
+		"cancel_reason = $3, canceled_by = $4 WHERE id = $1 "+
+		"AND status IN ($5, $6) RETURNING id", tid, TimedOut, reason,
+		Platform_actor, Scheduled, Running).Scan(&dummy); err != nil {
+		return nil, err
+	}
+
+	return GetTaskById(tid)
+}
+
+//########################################################
+
+// Executions
+//########################################################
+
+// This function records that the task was assigned to the worker.
+func RecordExecution(tid, wid int64) error {
+	var dummy string
+
+	return db.QueryRow("INSERT INTO executions (tid, wid) VALUES ($1, $2) "+
+		"RETURNING id", tid, wid).Scan(&dummy)
+}
+
+// This function returns the latest `size` executions of the worker. If
+// `current` is set only the executions of the tasks the worker still executes
+// (i.e. holds a lease on) are returned.
+func GetWorkerExecutions(wid int64, current bool, size int) ([]*Execution,
+	error) {
+	// declarations
+	var executions []*Execution
+	condition := ""
+	if current {
+		condition = "AND EXISTS (SELECT 1 FROM task_leases " +
+			"WHERE task_leases.tid = executions.tid " +
+			"AND task_leases.wid = executions.wid) "
+	}
+	rows, err := db.Query("SELECT "+task_columns+", executions.wid, "+
+		"executions.assigned FROM executions "+
+		"INNER JOIN tasks ON executions.tid = tasks.id "+task_joins+
+		" WHERE executions.wid = $1 "+condition+
+		"ORDER BY executions.assigned DESC, executions.id DESC LIMIT $2",
+		wid, size)
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch executions
+	defer rows.Close()
+	for rows.Next() {
+		execution := Execution{}
+		var execution_wid sql.NullInt64
+
+		if execution.Task, err = scanTask(rows, &execution_wid,
+			&execution.Assigned); err != nil {
+			return nil, err
+		}
+		if execution_wid.Valid {
+			execution.Wid = execution_wid.Int64
+		}
+
+		executions = append(executions, &execution)
+	}
+
+	return executions, nil
+}
+
+//########################################################
+
+// Leases
+//########################################################
+
+// This function grants the worker a lease on the task which expires after
+// `seconds` seconds unless it is renewed. An existing lease on the task is
+// replaced.
+func AcquireTaskLease(tid, wid, seconds int64) error {
+	var dummy string
+	if err := db.QueryRow("INSERT INTO task_leases (tid, wid, expires) "+
+		"VALUES ($1, $2, now() + $3 * interval '1 second') "+
+		"ON CONFLICT (tid) DO UPDATE SET wid = EXCLUDED.wid, "+
+		"expires = EXCLUDED.expires RETURNING tid", tid, wid, seconds).
+		Scan(&dummy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// This function extends the lease on the task by `seconds` seconds from now
+// and updates the `last_contact` time of the worker. Fails if the worker
+// (identified by its token) does not hold the lease.
+func RenewTaskLease(tid int64, worker_token string, seconds int64) error {
+	var dummy string
+	if err := db.QueryRow("WITH w AS ( "+
+		"UPDATE workers SET last_contact = now() WHERE token = $2 "+
+		"RETURNING id "+
+		") "+
+		"UPDATE task_leases SET expires = now() + $3 * interval '1 second' "+
+		"WHERE tid = $1 AND wid = (SELECT id FROM w) RETURNING tid", tid,
+		worker_token, seconds).Scan(&dummy); err != nil {
+		return err
+	}
+	return nil
+}
+
+// This function checks whether the worker (identified by its token) holds the
+// lease on the task.
+func HoldsTaskLease(tid int64, worker_token string) bool {
+	var dummy string
+	err := db.QueryRow("SELECT task_leases.tid FROM task_leases "+
+		"INNER JOIN workers ON task_leases.wid = workers.id "+
+		"WHERE task_leases.tid = $1 AND workers.token = $2", tid,
+		worker_token).Scan(&dummy)
+	return err == nil
+}
+
+// This function removes the lease on the task (if any).
+func ReleaseTaskLease(tid int64) {
+	var dummy string
+	db.QueryRow("DELETE FROM task_leases WHERE tid = $1", tid).Scan(&dummy)
+}
+
+// This function returns all leases that expired and belong to a task that is
+// still scheduled or running.
+func GetExpiredLeases() ([]*Lease, error) {
+	var leases []*Lease
+
+	rows, err := db.Query("SELECT task_leases.tid, task_leases.wid, "+
+		"workers.token, task_leases.expires FROM task_leases "+
+		"INNER JOIN workers ON task_leases.wid = workers.id "+
+		"INNER JOIN tasks ON task_leases.tid = tasks.id "+
+		"WHERE task_leases.expires < now() AND tasks.status IN ($1, $2)",
+		Scheduled, Running)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		lease := Lease{}
+		if err := rows.Scan(&lease.Tid, &lease.Wid, &lease.Worker_token,
+			&lease.Expires); err != nil {
+			return nil, err
+		}
+		leases = append(leases, &lease)
+	}
+
+	return leases, nil
+}
+
+//########################################################
+
+// Task output
+//########################################################
+
+// This function stores a chunk of the output of the task. The chunk is only
+// accepted from the worker holding the lease on the task. Chunks that were
+// already stored (i.e. retransmissions) are ignored.
+func AppendTaskOutput(worker_token string, chunk *OutputChunk) error {
+	var dummy string
+
+	if chunk.Stream != Stdout_stream && chunk.Stream != Stderr_stream {
+		return errors.New("Unknown output stream!")
+	}
+
+	if err := db.QueryRow("SELECT 42 FROM task_leases "+
+		"INNER JOIN workers ON task_leases.wid = workers.id "+
+		"WHERE task_leases.tid = $1 AND workers.token = $2", chunk.Tid,
+		worker_token).Scan(&dummy); err != nil {
+		return err
+	}
+
+	if err := db.QueryRow("INSERT INTO task_output_chunks (tid, seq, "+
+		"stream, content) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING "+
+		"RETURNING 42", chunk.Tid, chunk.Seq, chunk.Stream, chunk.Content).
+		Scan(&dummy); err != nil && err != sql.ErrNoRows {
+		return err
+	}
+
+	return nil
+}
+
+// This function deletes the output chunks of all tasks which are neither
+// pending, scheduled nor running and ended more than `retention` seconds ago.
+func DeleteFinishedTaskOutput(retention int64) {
+	var dummy string
+
+	db.QueryRow("DELETE FROM task_output_chunks WHERE tid IN ( "+
+		"SELECT id FROM tasks WHERE status NOT IN ($1, $2, $3) "+
+		"AND (end_time IS NULL "+
+		"OR end_time < now() - $4 * interval '1 second'))", Pending, Scheduled,
+		Running, retention).Scan(&dummy)
+}
+
+// This function returns the output chunks of the task whose sequence number is
+// greater than `after` ordered by their sequence number.
+func GetTaskOutput(tid, after int64) ([]*OutputChunk, error) {
+	//declarations
+	var chunks []*OutputChunk
+	rows, err := db.Query("SELECT tid, seq, stream, content "+
+		"FROM task_output_chunks WHERE tid = $1 AND seq > $2 ORDER BY seq",
+		tid, after)
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch chunks
+	defer rows.Close()
+	for rows.Next() {
+		chunk := OutputChunk{}
+		if err := rows.Scan(&chunk.Tid, &chunk.Seq, &chunk.Stream,
+			&chunk.Content); err != nil {
+			return nil, err
+		}
+		chunks = append(chunks, &chunk)
+	}
+
+	return chunks, nil
+}
+
+//########################################################
+
+// Artifacts
+//########################################################
+
+// Columns of the "artifacts" relation in the order expected by `scanArtifact`
+const artifact_columns = "artifacts.id, artifacts.tid, artifacts.name, " +
+	"artifacts.file_name, artifacts.content_type, artifacts.size, " +
+	"artifacts.parts, artifacts.sha256, artifacts.complete"
+
+// This function reads an artifact from a row that was selected using
+// `artifact_columns`
+func scanArtifact(row interface {
+	Scan(...interface{}) error
+}) (*Artifact, error) {
+	artifact := Artifact{}
+	var sha256 sql.NullString
+
+	if err := row.Scan(&artifact.Id, &artifact.Tid, &artifact.Name,
+		&artifact.File_name, &artifact.Content_type, &artifact.Size,
+		&artifact.Parts, &sha256, &artifact.Complete); err != nil {
+		return nil, err
+	}
+	if sha256.Valid {
+		artifact.Sha256 = sha256.String
+	}
+
+	return &artifact, nil
+}
+
+// This function creates a new (empty) artifact of the task on behalf of the
+// worker holding the lease on the task. An unfinished upload of an artifact
+// with the same name is replaced. Fails if the task already has a complete
+// artifact with the same name.
+func CreateArtifact(worker_token string, tid int64, name,
+	content_type string) (*Artifact, error) {
+	var dummy string
+
+	if err := db.QueryRow("SELECT 42 FROM task_leases "+
+		"INNER JOIN workers ON task_leases.wid = workers.id "+
+		"WHERE task_leases.tid = $1 AND workers.token = $2", tid,
+		worker_token).Scan(&dummy); err != nil {
+		return nil, err
+	}
+
+	file_name := nonExistingRandString(Token_length,
+		"SELECT 42 FROM artifacts WHERE file_name = $1")
+	return scanArtifact(db.QueryRow("INSERT INTO artifacts (tid, name, "+
+		"file_name, content_type) VALUES ($1, $2, $3, $4) "+
+		"ON CONFLICT (tid, name) DO UPDATE SET file_name = $3, "+
+		"content_type = $4, size = 0, parts = 0, sha256 = NULL "+
+		"WHERE NOT artifacts.complete RETURNING "+artifact_columns, tid, name,
+		file_name, content_type))
+}
+
+// This function returns the unfinished artifact specified by its id if the
+// worker holds the lease on the artifact's task.
+func GetUploadingArtifact(worker_token string, aid int64) (*Artifact,
+	error) {
+	return scanArtifact(db.QueryRow("SELECT "+artifact_columns+
+		" FROM artifacts "+
+		"INNER JOIN task_leases ON artifacts.tid = task_leases.tid "+
+		"INNER JOIN workers ON task_leases.wid = workers.id "+
+		"WHERE artifacts.id = $1 AND workers.token = $2 "+
+		"AND NOT artifacts.complete", aid, worker_token))
+}
+
+// This function updates the number of bytes and parts of the artifact that
+// were uploaded so far.
+func UpdateArtifactSize(aid, size, parts int64) {
+	var dummy string
+	db.QueryRow("UPDATE artifacts SET size = $1, parts = $2 WHERE id = $3",
+		size, parts, aid).Scan(&dummy)
+}
+
+// This function marks the upload of the artifact as finished and stores the
+// checksum of its content.
+func CompleteArtifact(aid int64, sha256 string) error {
+	var dummy string
+	return db.QueryRow("UPDATE artifacts SET sha256 = $1, complete = true "+
+		"WHERE id = $2 RETURNING id", sha256, aid).Scan(&dummy)
+}
+
+// This function deletes the artifact specified by its id.
+func DeleteArtifact(aid int64) {
+	var dummy string
+	db.QueryRow("DELETE FROM artifacts WHERE id = $1", aid).Scan(&dummy)
+}
+
+// This function returns the complete artifacts of the task ordered by their
+// name. No artifacts are returned if the user does not have access to the task.
+func GetArtifacts(token, tid string) ([]*Artifact, error) {
+	//declarations
+	var artifacts []*Artifact
+	rows, err := db.Query("SELECT "+artifact_columns+" FROM artifacts "+
+		"INNER JOIN tasks ON artifacts.tid = tasks.id "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
+		"WHERE group_tasks.uid = (SELECT id FROM users WHERE token = $1) "+
+		"AND artifacts.tid = $2 AND artifacts.complete "+
+		"ORDER BY artifacts.name", token, tid)
+	if err != nil {
+		return nil, err
+	}
+
+	// fetch artifacts
+	defer rows.Close()
+	for rows.Next() {
+		artifact, err := scanArtifact(rows)
+		if err != nil {
+			return nil, err
+		}
+		artifacts = append(artifacts, artifact)
+	}
+
+	return artifacts, nil
+}
+
+// Returns the complete artifact specified by its id and the id of its task.
+// Fails if the user does not have access to the task.
+func GetArtifact(token, tid, aid string) (*Artifact, error) {
+	return scanArtifact(db.QueryRow("SELECT "+artifact_columns+
+		" FROM artifacts "+
+		"INNER JOIN tasks ON artifacts.tid = tasks.id "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id "+
+		"WHERE group_tasks.uid = (SELECT id FROM users WHERE token = $1) "+
+		"AND artifacts.tid = $2 AND artifacts.id = $3 AND artifacts.complete",
+		token, tid, aid))
+}
+
+//########################################################
+
+// Queue
+//########################################################
+
+// Number of recently finished tasks whose average duration is used to estimate
+// when a queued task starts
+const queue_estimate_sample = 50
+
+// This function returns the queue of the pending tasks. If `token` is not empty
+// only the entries of the user's tasks are returned. The positions refer to the
+// order in which the tasks of all users are assigned (see `GetPendingTask`).
+// At most `size` entries are returned, skipping the first `offset` ones.
+func GetQueue(token string, offset, size int64) (*Queue, error) {
+	queue := Queue{}
+
+	if err := db.QueryRow("SELECT count(*) FROM tasks "+
+		"INNER JOIN group_tasks ON tasks.gid = group_tasks.id WHERE "+
+		pending_task_condition, Pending).Scan(&queue.Depth); err != nil {
+		return nil, err
+	}
+
+	entries, total, err := queryQueue("$2 = '' OR queue.token = $2", token,
+		offset, size)
+	if err != nil {
+		return nil, err
+	}
+	queue.Entries = entries
+	queue.Total = total
+
+	return &queue, nil
+}
+
+// This function returns the queue entry of the task or nil if the task does
+// not wait for a worker (e.g. because it was assigned or is delayed).
+func GetQueueEntry(tid int64) (*Queue_entry, error) {
+	entries, _, err := queryQueue("queue.id = $2", tid, 0, 1)
+	if err != nil || len(entries) == 0 {
+		return nil, err
+	}
+
+	return entries[0], nil
+}
+
+// Helper to fetch the entries of the queue that satisfy `filter` (where $2
+// refers to `argument`), at most `size` of them starting at `offset`. The
+// eligible workers and the estimated start are determined for each of them.
+// Returns the entries and the number of entries satisfying `filter`.
+func queryQueue(filter string, argument interface{}, offset,
+	size int64) ([]*Queue_entry, int64, error) {
+	// declarations
+	var entries []*Queue_entry
+	var total int64
+
+	rows, err := db.Query("SELECT "+task_columns+", queue.position, "+
+		"count(*) OVER () "+
+		"FROM (SELECT tasks.id, users.token, "+
+		"row_number() OVER (ORDER BY "+pending_task_order+") AS position "+
+		"FROM tasks "+pending_task_joins+" "+
+		"WHERE "+pending_task_condition+") AS queue "+
+		"INNER JOIN tasks ON queue.id = tasks.id "+task_joins+
+		" WHERE "+filter+" ORDER BY queue.position LIMIT $3 OFFSET $4",
+		Pending, argument, size, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	for rows.Next() {
+		entry := Queue_entry{}
+		if entry.Task, err = scanTask(rows, &entry.Position,
+			&total); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		entries = append(entries, &entry)
+	}
+	rows.Close()
+	if len(entries) == 0 {
+		return entries, total, nil
+	}
+
+	workers, err := queryWorkers("SELECT " + worker_columns + " FROM workers " +
+		"WHERE active AND NOT draining")
+	if err != nil {
+		return nil, 0, err
+	}
+	duration, err := getRecentTaskDuration()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	for _, entry := range entries {
+		task := entry.Task
+		var capacity int64
+		for _, worker := range workers {
+			if (worker.Uid != task.User.Id && !worker.Shared) ||
+				!worker.Satisfies(task.Bot.Requirements) ||
+				!task.Routing.Permits(worker, task.User.Id) {
+				continue
+			}
+			entry.Eligible_workers++
+			capacity += worker.Capacity
+			if worker.Occupied < worker.Capacity {
+				entry.Free_slots += worker.Capacity - worker.Occupied
+			}
+		}
+		entry.Estimated_start = estimateStart(entry.Position, capacity,
+			entry.Free_slots, duration, now)
+	}
+
+	return entries, total, nil
+}
+
+// This function returns the average duration in seconds of the recently
+// finished tasks. The result is not valid if no task finished yet.
+func getRecentTaskDuration() (sql.NullFloat64, error) {
+	var duration sql.NullFloat64
+
+	err := db.QueryRow("SELECT avg(extract(epoch FROM end_time - start_time)) "+
+		"FROM (SELECT start_time, end_time FROM tasks "+
+		"WHERE status IN ($1, $2) AND start_time IS NOT NULL "+
+		"AND end_time IS NOT NULL ORDER BY end_time DESC LIMIT $3) AS recent",
+		Succeeded, Failed, queue_estimate_sample).Scan(&duration)
+
+	return duration, err
+}
+
+// Helper to estimate the start of the task at the given position. The tasks in
+// front of it take the free slots first, the remaining ones are executed in
+// waves using the whole capacity of the eligible workers, each wave taking
+// `duration` seconds. Returns nil if there is no eligible worker or no
+// duration to base the estimate on.
+func estimateStart(position, capacity, free int64, duration sql.NullFloat64,
+	now time.Time) *time.Time {
+	ahead := position - 1
+	if ahead < free {
+		return &now
+	}
+	if capacity == 0 || !duration.Valid {
+		return nil
+	}
+
+	waves := (ahead-free)/capacity + 1
+	start := now.Add(time.Duration(float64(waves) * duration.Float64 *
+		float64(time.Second)))
+
+	return &start
+}
+
+//########################################################
+
+// ScheduledTask
+//########################################################
+
+// This function creates a *ScheduledTask from the given parameters,
+// makes a database entry in the table group_tasks and schedule_tasks
+// and returns it
+func CreateScheduledTask(token string, pid string, bid string, name string,
+	next time.Time, cron_exp, timezone string, misfire,
+	misfire_limit int64) (*ScheduledTask, error) {
 	var gid int64
 	if err := db.QueryRow("WITH row AS ("+
 		"INSERT INTO group_tasks (uid, pid, bid) VALUES ("+
 		"(SELECT id FROM users WHERE token = $1), $2, $3) RETURNING id"+
 		")"+
-		"INSERT INTO schedule_tasks (id, name, status, next, cron) "+
-		"VALUES ((SELECT id FROM row), $4, $5, $6, $7) RETURNING id", token,
-		pid, bid, name, Active, next, cron_exp).Scan(&gid); err != nil {
+		"INSERT INTO schedule_tasks (id, name, status, next, cron, timezone, "+
+		"misfire, misfire_limit) VALUES ((SELECT id FROM row), $4, $5, $6, $7, "+
+		"$8, $9, $10) RETURNING id", token, pid, bid, name, Active, next.UTC(),
+		cron_exp, timezone, misfire, misfire_limit).
+		Scan(&gid); err != nil {
 		return nil, err
 	}
 	return GetScheduledTask(gid)
@@ -1148,9 +2502,10 @@ func GetScheduledTask(stid int64) (*ScheduledTask, error) {
 	var next pq.NullTime
 	task := ScheduledTask{}
 
-	if err := db.QueryRow("SELECT * FROM schedule_tasks WHERE id=$1", stid).
-		Scan(&task.Id, &task.Name, &task.Status, &next,
-		&task.Cron); err != nil {
+	if err := db.QueryRow("SELECT id, name, status, next, cron, timezone, "+
+		"misfire, misfire_limit FROM schedule_tasks WHERE id=$1", stid).
+		Scan(&task.Id, &task.Name, &task.Status, &next, &task.Cron,
+		&task.Timezone, &task.Misfire, &task.Misfire_limit); err != nil {
 		return nil, err
 	}
 
@@ -1173,7 +2528,10 @@ func GetScheduledTask(stid int64) (*ScheduledTask, error) {
 // This function returns all *ScheduledTaskInstances (containing
 // a *ScheduledTask and a list of *Task's) which are referred to
 // the users token
-func GetScheduledTasks(token string) ([]*ScheduledTaskInstances, error) {
+// If `wid` is not 0 only the tasks executed by this worker and their groups
+// are returned.
+func GetScheduledTasks(token string, wid int64) ([]*ScheduledTaskInstances,
+	error) {
 	var tasks []*ScheduledTaskInstances
 
 	rows, err := db.Query("SELECT group_tasks.id FROM schedule_tasks "+
@@ -1196,10 +2554,13 @@ func GetScheduledTasks(token string) ([]*ScheduledTaskInstances, error) {
 		if err != nil {
 			return nil, err
 		}
-		child_tasks, err := GetChildTasks(tid)
+		child_tasks, err := GetChildTasks(tid, wid)
 		if err != nil {
 			return nil, err
 		}
+		if wid != 0 && len(child_tasks) == 0 {
+			continue
+		}
 		tasks = append(tasks, &ScheduledTaskInstances{
 			Task:        task,
 			Child_tasks: child_tasks,
@@ -1208,6 +2569,27 @@ func GetScheduledTasks(token string) ([]*ScheduledTaskInstances, error) {
 	return tasks, nil
 }
 
+// This function updates the definition of a *ScheduledTask, i.e. its bot,
+// name, cron expression, time zone and misfire policy, along with its next
+// execution time (stored in UTC) and returns it. The executions of the task
+// are kept.
+func UpdateScheduledTask(stid, bid int64, name string, next time.Time,
+	cron_exp, timezone string, misfire, misfire_limit int64) (*ScheduledTask,
+	error) {
+	var dummy string
+	if err := db.QueryRow("WITH g AS ("+
+		"UPDATE group_tasks SET bid = $2 WHERE id = $1 RETURNING id"+
+		") "+
+		"UPDATE schedule_tasks SET name = $3, next = $4, cron = $5, "+
+		"timezone = $6, misfire = $7, misfire_limit = $8 "+
+		"WHERE id = (SELECT id FROM g) RETURNING id", stid, bid, name,
+		next.UTC(), cron_exp, timezone, misfire, misfire_limit).
+		Scan(&dummy); err != nil {
+		return nil, err
+	}
+	return GetScheduledTask(stid)
+}
+
 // This function updates the status of a *ScheduledTask with the
 // provided value
 func UpdateScheduledTaskStatus(stid int64, status int) error {
@@ -1220,11 +2602,11 @@ func UpdateScheduledTaskStatus(stid int64, status int) error {
 }
 
 // This function updates the next execution time of a *ScheduledTask
-// with the provided value
+// with the provided value (stored in UTC)
 func UpdateNextScheduleTime(stid int64, next time.Time) error {
 	var dummy string
 	if err := db.QueryRow("UPDATE schedule_tasks SET next=$1 WHERE id=$2 "+
-		"RETURNING id", next, stid).Scan(&dummy); err != nil {
+		"RETURNING id", next.UTC(), stid).Scan(&dummy); err != nil {
 		return err
 	}
 	return nil
@@ -1304,7 +2686,10 @@ func GetOneTimeTask(otid int64) (*OneTimeTask, error) {
 // This function returns all *OneTimeTaskInstances (containing
 // a *OneTimeTask and a list of *Task's) which are referred to
 // the users token
-func GetOneTimeTasks(token string) ([]*OneTimeTaskInstances, error) {
+// If `wid` is not 0 only the tasks executed by this worker and their groups
+// are returned.
+func GetOneTimeTasks(token string, wid int64) ([]*OneTimeTaskInstances,
+	error) {
 	var tasks []*OneTimeTaskInstances
 
 	rows, err := db.Query("SELECT group_tasks.id FROM onetime_tasks "+
@@ -1327,10 +2712,13 @@ func GetOneTimeTasks(token string) ([]*OneTimeTaskInstances, error) {
 		if err != nil {
 			return nil, err
 		}
-		child_tasks, err := GetChildTasks(tid)
+		child_tasks, err := GetChildTasks(tid, wid)
 		if err != nil {
 			return nil, err
 		}
+		if wid != 0 && len(child_tasks) == 0 {
+			continue
+		}
 		tasks = append(tasks, &OneTimeTaskInstances{
 			Task:        task,
 			Child_tasks: child_tasks,
@@ -1350,6 +2738,22 @@ func UpdateOneTimeTaskStatus(otid int64, status int) error {
 	return nil
 }
 
+// This function updates the definition of a *OneTimeTask, i.e. its bot, name
+// and execution time, and returns it. The executions of the task are kept.
+func UpdateOneTimeTask(otid, bid int64, name string,
+	exec_time time.Time) (*OneTimeTask, error) {
+	var dummy string
+	if err := db.QueryRow("WITH g AS ("+
+		"UPDATE group_tasks SET bid = $2 WHERE id = $1 RETURNING id"+
+		") "+
+		"UPDATE onetime_tasks SET name = $3, exec_time = $4 "+
+		"WHERE id = (SELECT id FROM g) RETURNING id", otid, bid, name,
+		exec_time).Scan(&dummy); err != nil {
+		return nil, err
+	}
+	return GetOneTimeTask(otid)
+}
+
 // This function returns the id's of all *OneTimeTask's which have
 // the specific status
 func GetOneTimeTaskIdsWithStatus(status int) ([]int64, error) {
@@ -1433,7 +2837,10 @@ func GetInstantTask(itid int64) (*InstantTask, error) {
 // This function returns all *InstantTaskInstances (containing
 // a *InstantTask and a list of *Task's) which are referred to
 // the users token
-func GetInstantTasks(token string) ([]*InstantTaskInstances, error) {
+// If `wid` is not 0 only the tasks executed by this worker and their groups
+// are returned.
+func GetInstantTasks(token string, wid int64) ([]*InstantTaskInstances,
+	error) {
 	var tasks []*InstantTaskInstances
 
 	rows, err := db.Query("SELECT group_tasks.id FROM instant_tasks "+
@@ -1456,10 +2863,13 @@ func GetInstantTasks(token string) ([]*InstantTaskInstances, error) {
 		if err != nil {
 			return nil, err
 		}
-		child_tasks, err := GetChildTasks(tid)
+		child_tasks, err := GetChildTasks(tid, wid)
 		if err != nil {
 			return nil, err
 		}
+		if wid != 0 && len(child_tasks) == 0 {
+			continue
+		}
 		tasks = append(tasks, &InstantTaskInstances{
 			Task:        task,
 			Child_tasks: child_tasks,
@@ -1496,7 +2906,10 @@ func CreateNewEventTask(token string, pid string, bid string, name string,
 // This function returns all *EventTaskInstances (containing
 // a *EventTask and a list of *Task's) which are referred to
 // the users token
-func GetEventTasks(token string) ([]*EventTaskInstances, error) {
+// If `wid` is not 0 only the tasks executed by this worker and their groups
+// are returned.
+func GetEventTasks(token string, wid int64) ([]*EventTaskInstances,
+	error) {
 	var tasks []*EventTaskInstances
 
 	rows, err := db.Query("SELECT group_tasks.id FROM event_tasks "+
@@ -1519,10 +2932,13 @@ func GetEventTasks(token string) ([]*EventTaskInstances, error) {
 		if err != nil {
 			return nil, err
 		}
-		child_tasks, err := GetChildTasks(tid)
+		child_tasks, err := GetChildTasks(tid, wid)
 		if err != nil {
 			return nil, err
 		}
+		if wid != 0 && len(child_tasks) == 0 {
+			continue
+		}
 		tasks = append(tasks, &EventTaskInstances{
 			Task:        task,
 			Child_tasks: child_tasks,
@@ -1601,6 +3017,23 @@ func GetEventTask(etid int64) (*EventTask, error) {
 	return &task, nil
 }
 
+// This function updates the definition of an *EventTask, i.e. its bot, name
+// and event, and returns it. The executions of the task are kept. The hook on
+// GitHub is not touched.
+func UpdateEventTask(etid, bid int64, name string, event int64) (*EventTask,
+	error) {
+	var dummy string
+	if err := db.QueryRow("WITH g AS ("+
+		"UPDATE group_tasks SET bid = $2 WHERE id = $1 RETURNING id"+
+		") "+
+		"UPDATE event_tasks SET name = $3, event = $4 "+
+		"WHERE id = (SELECT id FROM g) RETURNING id", etid, bid, name,
+		event).Scan(&dummy); err != nil {
+		return nil, err
+	}
+	return GetEventTask(etid)
+}
+
 // This function sets a new hookId for the specified EventTask
 func SetHookId(etid int64, hook_id int64) error {
 	var dummy string
@@ -1659,3 +3092,217 @@ func CancelTaskGroup(tid string) (interface{}, error) {
 
 	return nil, fmt.Errorf("Ups! This should not happen!")
 }
+
+// This function returns the *ScheduledTask, *EventTask, *OneTimeTask or
+// *InstantTask provided by his id as an interface. Fails if the task does not
+// belong to the user.
+func GetTaskGroup(tid, token string) (interface{}, error) {
+	var task_type int
+
+	if err := db.QueryRow("SELECT CASE "+
+		"WHEN EXISTS (SELECT 42 FROM schedule_tasks WHERE id = $1) THEN 1 "+
+		"WHEN EXISTS (SELECT 42 FROM event_tasks WHERE id = $1) THEN 2 "+
+		"WHEN EXISTS (SELECT 42 FROM onetime_tasks WHERE id = $1) THEN 3 "+
+		"ELSE 4 END FROM group_tasks "+
+		"INNER JOIN users ON group_tasks.uid = users.id "+
+		"WHERE group_tasks.id = $1 AND users.token = $2", tid, token).
+		Scan(&task_type); err != nil {
+		return nil, err
+	}
+
+	gid, _ := strconv.ParseInt(tid, 10, 64)
+
+	switch {
+	case task_type == 1:
+		return GetScheduledTask(gid)
+	case task_type == 2:
+		return GetEventTask(gid)
+	case task_type == 3:
+		return GetOneTimeTask(gid)
+	}
+
+	return GetInstantTask(gid)
+}
+
+// This function pauses the *ScheduledTask, *EventTask or *OneTimeTask
+// provided by his id, i.e. sets its status from Active to Paused, and returns
+// it as an interface. Fails if the task does not belong to the user or is not
+// active.
+func PauseTaskGroup(tid, token string) (interface{}, error) {
+	return updateTaskGroupStatus(tid, token, Active, Paused)
+}
+
+// This function resumes the paused *ScheduledTask, *EventTask or *OneTimeTask
+// provided by his id, i.e. sets its status from Paused to Active, and returns
+// it as an interface. Fails if the task does not belong to the user or is not
+// paused.
+func ResumeTaskGroup(tid, token string) (interface{}, error) {
+	return updateTaskGroupStatus(tid, token, Paused, Active)
+}
+
+// Helper to change the status of the user's scheduled, event or one time task
+// from `from` to `to`.
+func updateTaskGroupStatus(tid, token string, from, to int) (interface{},
+	error) {
+	var task_type int
+
+	if err := db.QueryRow("WITH g AS ( "+
+		"SELECT group_tasks.id FROM group_tasks "+
+		"INNER JOIN users ON group_tasks.uid = users.id "+
+		"WHERE group_tasks.id = $1 AND users.token = $2 "+
+		"), s AS ( "+
+		"UPDATE schedule_tasks SET status = $4 "+
+		"WHERE id IN (SELECT id FROM g) AND status = $3 RETURNING 1 "+
+		"), e AS ( "+
+		"UPDATE event_tasks SET status = $4 "+
+		"WHERE id IN (SELECT id FROM g) AND status = $3 RETURNING 2 "+
+		"), o AS ( "+
+		"UPDATE onetime_tasks SET status = $4 "+
+		"WHERE id IN (SELECT id FROM g) AND status = $3 RETURNING 3 "+
+		") "+
+		"SELECT CASE "+
+		"WHEN EXISTS (SELECT 42 FROM s) THEN 1 "+
+		"WHEN EXISTS (SELECT 42 FROM e) THEN 2 "+
+		"WHEN EXISTS (SELECT 42 FROM o) THEN 3 "+
+		"ELSE 0 END", tid, token, from, to).Scan(&task_type); err != nil {
+		return nil, err
+	}
+
+	gid, _ := strconv.ParseInt(tid, 10, 64)
+
+	switch {
+	case task_type == 1:
+		return GetScheduledTask(gid)
+	case task_type == 2:
+		return GetEventTask(gid)
+	case task_type == 3:
+		return GetOneTimeTask(gid)
+	}
+
+	return nil, fmt.Errorf("The action is not %s!",
+		strings.ToLower(task_group_status_string(int64(from))))
+}
+
+//########################################################
+
+// Task group settings
+//########################################################
+
+// This function returns the execution settings of the task group specified by
+// its id. Fails if the task group does not belong to the user.
+func GetTaskGroupSettings(gid, token string) (*TaskGroupSettings, error) {
+	// declarations
+	settings := TaskGroupSettings{}
+	var bid int64
+	var max_retries, timeout, routing sql.NullInt64
+	var allowed_workers sql.NullString
+
+	if err := db.QueryRow("SELECT group_tasks.id, group_tasks.bid, "+
+		"group_tasks.max_retries, group_tasks.priority, group_tasks.timeout, "+
+		"group_tasks.routing, group_tasks.allowed_workers, "+
+		"group_tasks.overlap "+
+		"FROM group_tasks INNER JOIN users ON group_tasks.uid = users.id "+
+		"WHERE group_tasks.id = $1 AND users.token = $2", gid, token).
+		Scan(&settings.Id, &bid, &max_retries, &settings.Priority,
+		&timeout, &routing, &allowed_workers, &settings.Overlap); err != nil {
+		return nil, err
+	}
+	settings.Routing = makeRoutingRule(routing, allowed_workers)
+
+	if max_retries.Valid {
+		settings.Max_retries = &max_retries.Int64
+	}
+	if timeout.Valid {
+		settings.Timeout = &timeout.Int64
+	}
+
+	bot, err := GetBot(strconv.FormatInt(bid, 10))
+	if err != nil {
+		return nil, err
+	}
+	settings.Bot = bot
+
+	return &settings, nil
+}
+
+// This function stores the execution settings of the task group. Fails if the
+// task group does not belong to the user.
+func UpdateTaskGroupSettings(token string, settings *TaskGroupSettings) error {
+	var dummy string
+	var max_retries, timeout, routing sql.NullInt64
+	// nil is stored as NULL
+	var allowed_workers pq.Int64Array
+
+	if settings.Max_retries != nil {
+		if *settings.Max_retries < 0 {
+			return errors.New("The number of retries must not be negative!")
+		}
+		max_retries.Int64, max_retries.Valid = *settings.Max_retries, true
+	}
+	if settings.Priority < -Max_priority_adjustment ||
+		settings.Priority > Max_priority_adjustment {
+		return fmt.Errorf("The priority must be between %d and %d!",
+			-Max_priority_adjustment, Max_priority_adjustment)
+	}
+	if settings.Timeout != nil {
+		if *settings.Timeout <= 0 {
+			return errors.New("The timeout must be positive!")
+		}
+		timeout.Int64, timeout.Valid = *settings.Timeout, true
+	}
+	if settings.Routing != nil {
+		if err := checkRoutingRule(token, settings.Routing); err != nil {
+			return err
+		}
+		routing.Int64, routing.Valid = settings.Routing.Policy, true
+		allowed_workers = makeIdArray(settings.Routing.Workers)
+	}
+	if settings.Overlap < Overlap_allow ||
+		settings.Overlap > Overlap_queue_one {
+		return errors.New("Unknown overlap policy!")
+	}
+
+	if err := db.QueryRow("UPDATE group_tasks SET max_retries = $1, "+
+		"priority = $2, timeout = $3, routing = $4, allowed_workers = $5, "+
+		"overlap = $6 "+
+		"WHERE id = $7 AND uid = (SELECT id FROM users WHERE token = $8) "+
+		"RETURNING id", max_retries, settings.Priority, timeout, routing,
+		allowed_workers, settings.Overlap, settings.Id,
+		token).Scan(&dummy); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// This function returns the number of times a failed execution of the task
+// group is retried. The setting of the task group takes precedence over the
+// one of the bot.
+func GetRetryLimit(gid int64) (int64, error) {
+	var limit int64
+
+	if err := db.QueryRow("SELECT COALESCE(group_tasks.max_retries, "+
+		"bots.max_retries) FROM group_tasks "+
+		"INNER JOIN bots ON group_tasks.bid = bots.id "+
+		"WHERE group_tasks.id = $1", gid).Scan(&limit); err != nil {
+		return 0, err
+	}
+
+	return limit, nil
+}
+
+// This function returns the maximal duration in seconds of an execution of the
+// task group. The setting of the task group takes precedence over the one of
+// the bot.
+func GetTaskTimeout(gid int64) (int64, error) {
+	var timeout int64
+
+	if err := db.QueryRow("SELECT COALESCE(group_tasks.timeout, "+
+		"bots.timeout) FROM group_tasks "+
+		"INNER JOIN bots ON group_tasks.bid = bots.id "+
+		"WHERE group_tasks.id = $1", gid).Scan(&timeout); err != nil {
+		return 0, err
+	}
+
+	return timeout, nil
+}
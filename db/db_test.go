@@ -0,0 +1,333 @@
+package db
+
+import (
+	"github.com/AnalysisBotsPlatform/platform/internal/testdb"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// Connects to a fresh schema of the test database (see `testdb.SetUp`). Skips
+// the test if no test database is configured.
+func setUpTestDB(t *testing.T) {
+	_, config := testdb.SetUp(t)
+	if err := OpenDB(config.Host, config.User, config.Password,
+		config.Name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(CloseDB)
+}
+
+// Helper to create a user with the given share weight. Returns the user's id
+// and token. The worker registration token is "worker-" followed by `name`.
+func createTestUser(t *testing.T, name string, admin bool,
+	weight int64) (int64, string) {
+	var uid int64
+	token := "token-" + name
+
+	if err := db.QueryRow("INSERT INTO users (gh_id, username, token, "+
+		"worker_token, admin, share_weight) VALUES ((SELECT count(*) + 1 "+
+		"FROM users), $1, $2, $3, $4, $5) RETURNING id", name, token,
+		"worker-"+name, admin, weight).Scan(&uid); err != nil {
+		t.Fatal(err)
+	}
+
+	return uid, token
+}
+
+// Helper to create a project and a bot. Returns their ids.
+func createTestProjectAndBot(t *testing.T) (int64, int64) {
+	var pid, bid int64
+
+	if err := db.QueryRow("INSERT INTO projects (gh_id, name) " +
+		"VALUES (1, 'owner/project') RETURNING id").Scan(&pid); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("INSERT INTO bots (name) VALUES ('owner/bot') " +
+		"RETURNING id").Scan(&bid); err != nil {
+		t.Fatal(err)
+	}
+
+	return pid, bid
+}
+
+// Helper to create an instant task group of the user with the given priority
+// adjustment. Returns its id.
+func createTestGroup(t *testing.T, uid, pid, bid, priority int64) int64 {
+	var gid int64
+
+	if err := db.QueryRow("INSERT INTO group_tasks (uid, pid, bid, priority) "+
+		"VALUES ($1, $2, $3, $4) RETURNING id", uid, pid, bid, priority).
+		Scan(&gid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO instant_tasks (id) VALUES ($1)",
+		gid); err != nil {
+		t.Fatal(err)
+	}
+
+	return gid
+}
+
+// Helper to create `n` pending tasks of the task group. Returns their ids in
+// the order of their creation.
+func createTestTasks(t *testing.T, gid int64, n int) []int64 {
+	var tids []int64
+	for i := 0; i < n; i++ {
+		task, err := CreateNewChildTask(gid, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tids = append(tids, task.Id)
+	}
+
+	return tids
+}
+
+// Helper to register a worker for the user with the given name.
+func createTestWorker(t *testing.T, name string, shared bool) *Worker {
+	token, err := CreateWorker("worker-"+name, "worker of "+name, shared, nil,
+		"", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	worker, err := GetWorker(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return worker
+}
+
+// Helper to let the worker pick pending tasks one after another (without
+// finishing them) and to check that they are picked in the `expected` order.
+func expectAssignments(t *testing.T, worker *Worker, expected []int64) {
+	for i, tid := range expected {
+		task, err := GetPendingTask(worker)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if task == nil {
+			t.Fatalf("assignment %d: no task, want task %d", i, tid)
+		}
+		if task.Id != tid {
+			t.Fatalf("assignment %d: task %d, want task %d", i, task.Id, tid)
+		}
+		if err := AcquireTaskLease(task.Id, worker.Id, 60); err != nil {
+			t.Fatal(err)
+		}
+		UpdateTaskStatus(task.Id, Scheduled)
+	}
+}
+
+func TestGetPendingTaskOrdersByPriority(t *testing.T) {
+	setUpTestDB(t)
+	uid, _ := createTestUser(t, "alice", false, 1)
+	pid, bid := createTestProjectAndBot(t)
+	normal := createTestTasks(t, createTestGroup(t, uid, pid, bid, 0), 2)
+	urgent := createTestTasks(t, createTestGroup(t, uid, pid, bid, 10), 1)
+	low := createTestTasks(t, createTestGroup(t, uid, pid, bid, -10), 1)
+	worker := createTestWorker(t, "alice", false)
+
+	// higher priorities first, the oldest task among equal priorities
+	expectAssignments(t, worker, []int64{urgent[0], normal[0], normal[1],
+		low[0]})
+
+	if task, err := GetPendingTask(worker); err != nil || task != nil {
+		t.Fatalf("got %v (%v), want no task", task, err)
+	}
+}
+
+func TestGetPendingTaskInterleavesUsers(t *testing.T) {
+	setUpTestDB(t)
+	createTestUser(t, "admin", true, 1)
+	alice, _ := createTestUser(t, "alice", false, 1)
+	bob, _ := createTestUser(t, "bob", false, 1)
+	pid, bid := createTestProjectAndBot(t)
+	alice_tasks := createTestTasks(t, createTestGroup(t, alice, pid, bid, 0),
+		3)
+	bob_tasks := createTestTasks(t, createTestGroup(t, bob, pid, bid, 0), 3)
+	worker := createTestWorker(t, "admin", true)
+
+	// although all of Alice's tasks are older, Bob gets every other worker
+	expectAssignments(t, worker, []int64{alice_tasks[0], bob_tasks[0],
+		alice_tasks[1], bob_tasks[1], alice_tasks[2], bob_tasks[2]})
+}
+
+func TestGetPendingTaskWeightsShares(t *testing.T) {
+	setUpTestDB(t)
+	createTestUser(t, "admin", true, 1)
+	alice, _ := createTestUser(t, "alice", false, 1)
+	bob, _ := createTestUser(t, "bob", false, 1)
+	if err := SetUserShareWeight(strconv.FormatInt(bob, 10), 2); err != nil {
+		t.Fatal(err)
+	}
+	pid, bid := createTestProjectAndBot(t)
+	alice_tasks := createTestTasks(t, createTestGroup(t, alice, pid, bid, 0),
+		2)
+	bob_tasks := createTestTasks(t, createTestGroup(t, bob, pid, bid, 0), 4)
+	worker := createTestWorker(t, "admin", true)
+
+	// Bob occupies up to twice as many shared workers as Alice
+	expectAssignments(t, worker, []int64{alice_tasks[0], bob_tasks[0],
+		bob_tasks[1], alice_tasks[1], bob_tasks[2], bob_tasks[3]})
+}
+
+func TestGetPendingTaskPrefersPriorityOverShare(t *testing.T) {
+	setUpTestDB(t)
+	createTestUser(t, "admin", true, 1)
+	alice, _ := createTestUser(t, "alice", false, 1)
+	bob, _ := createTestUser(t, "bob", false, 1)
+	pid, bid := createTestProjectAndBot(t)
+	alice_tasks := createTestTasks(t, createTestGroup(t, alice, pid, bid, 10),
+		2)
+	bob_tasks := createTestTasks(t, createTestGroup(t, bob, pid, bid, 0), 1)
+	worker := createTestWorker(t, "admin", true)
+
+	expectAssignments(t, worker, []int64{alice_tasks[0], alice_tasks[1],
+		bob_tasks[0]})
+}
+
+func TestSetUserShareWeight(t *testing.T) {
+	setUpTestDB(t)
+	uid, token := createTestUser(t, "alice", false, 1)
+	id := strconv.FormatInt(uid, 10)
+
+	for _, weight := range []int64{0, -1} {
+		if err := SetUserShareWeight(id, weight); err == nil {
+			t.Errorf("weight %d accepted", weight)
+		}
+	}
+	if err := SetUserShareWeight(id, 3); err != nil {
+		t.Fatal(err)
+	}
+	user, err := GetUser(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Share_weight != 3 {
+		t.Errorf("share weight %d, want 3", user.Share_weight)
+	}
+}
+
+func TestGetWorkerExecutions(t *testing.T) {
+	setUpTestDB(t)
+	uid, _ := createTestUser(t, "alice", false, 1)
+	pid, bid := createTestProjectAndBot(t)
+	tids := createTestTasks(t, createTestGroup(t, uid, pid, bid, 0), 2)
+	worker := createTestWorker(t, "alice", false)
+	for _, tid := range tids {
+		if err := RecordExecution(tid, worker.Id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	executions, err := GetWorkerExecutions(worker.Id, false, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(executions) != 2 {
+		t.Fatalf("%d executions, want 2", len(executions))
+	}
+	// the latest execution first, along with its task's project and bot
+	execution := executions[0]
+	if execution.Task.Id != tids[1] || execution.Wid != worker.Id ||
+		execution.Task.Project.Name != "owner/project" ||
+		execution.Task.Bot.Name != "owner/bot" {
+		t.Errorf("got execution of task %d on worker %d (%s, %s)",
+			execution.Task.Id, execution.Wid, execution.Task.Project.Name,
+			execution.Task.Bot.Name)
+	}
+
+	// the executions outlive the worker
+	if err := DeleteWorker("token-alice", worker.Token); err != nil {
+		t.Fatal(err)
+	}
+	var count int64
+	if err := db.QueryRow("SELECT count(*) FROM executions " +
+		"WHERE wid IS NULL").Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 2 {
+		t.Errorf("%d executions kept, want 2", count)
+	}
+}
+
+func TestGetQueuePages(t *testing.T) {
+	setUpTestDB(t)
+	uid, token := createTestUser(t, "alice", false, 1)
+	pid, bid := createTestProjectAndBot(t)
+	tids := createTestTasks(t, createTestGroup(t, uid, pid, bid, 0), 3)
+
+	queue, err := GetQueue(token, 1, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if queue.Depth != 3 || queue.Total != 3 || len(queue.Entries) != 1 {
+		t.Fatalf("depth %d, total %d, %d entries, want 3, 3, 1",
+			queue.Depth, queue.Total, len(queue.Entries))
+	}
+	entry := queue.Entries[0]
+	if entry.Position != 2 || entry.Task.Id != tids[1] ||
+		entry.Task.User.User_name != "alice" ||
+		entry.Task.Bot.Name != "owner/bot" {
+		t.Errorf("got task %d of %s (%s) at position %d, want task %d at 2",
+			entry.Task.Id, entry.Task.User.User_name, entry.Task.Bot.Name,
+			entry.Position, tids[1])
+	}
+
+	if queue, err = GetQueue("token-bob", 0, 10); err != nil {
+		t.Fatal(err)
+	}
+	if queue.Total != 0 || len(queue.Entries) != 0 {
+		t.Errorf("%d entries of another user", len(queue.Entries))
+	}
+}
+
+func TestCreateWorkerFailsWithoutRow(t *testing.T) {
+	setUpTestDB(t)
+	createTestUser(t, "alice", false, 1)
+
+	// the label does not fit into the table, thus no worker is created
+	label := strings.Repeat("x", 51)
+	if token, err := CreateWorker("worker-alice", "worker", false,
+		[]string{label}, "", 1); err == nil {
+		t.Errorf("got token %q for a worker that was not stored", token)
+	}
+}
+
+func TestUpdateTaskResultKeepsCanceledTask(t *testing.T) {
+	setUpTestDB(t)
+	uid, _ := createTestUser(t, "alice", false, 1)
+	pid, bid := createTestProjectAndBot(t)
+	tid := createTestTasks(t, createTestGroup(t, uid, pid, bid, 0), 1)[0]
+	UpdateTaskStatus(tid, Running)
+	CancelTask(tid, Canceled_by_user, "alice")
+
+	if _, err := UpdateTaskResult(tid, &TaskResult{}, false); err == nil {
+		t.Error("the result of a canceled task was stored")
+	}
+	task, err := GetTaskById(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != Canceled {
+		t.Errorf("status %s, want canceled", task.StatusString())
+	}
+}
+
+func TestGetTaskOfAnotherUser(t *testing.T) {
+	setUpTestDB(t)
+	alice, alice_token := createTestUser(t, "alice", false, 1)
+	_, bob_token := createTestUser(t, "bob", false, 1)
+	pid, bid := createTestProjectAndBot(t)
+	tid := strconv.FormatInt(createTestTasks(t,
+		createTestGroup(t, alice, pid, bid, 0), 1)[0], 10)
+
+	if _, err := GetTask(tid, alice_token); err != nil {
+		t.Fatal(err)
+	}
+	if task, err := GetTask(tid, bob_token); err == nil {
+		t.Errorf("got task %d of another user", task.Id)
+	}
+}
@@ -0,0 +1,81 @@
+// Test database shared by the tests of the platform's packages. Every test
+// gets a fresh schema containing the tables of the database setup script.
+package testdb
+
+import (
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Database setup script whose tables are created for every test, relative to
+// this file
+const setup_script = "../../db/conf/setup-database.sql"
+
+// Connection parameters of the test database (see `db.OpenDB`)
+type Config struct {
+	Host     string
+	User     string
+	Password string
+	Name     string
+}
+
+// Connects to the test database given by the environment variables
+// TEST_DB_HOST, TEST_DB_USER, TEST_DB_PASS and TEST_DB_NAME and creates the
+// tables of the setup script in a fresh schema, which is dropped after the
+// test. Every connection opened during the test (e.g. via `db.OpenDB` using
+// the returned parameters) uses the schema. The returned connection may be
+// used to prepare the test's data. Skips the test if no test database is
+// configured.
+func SetUp(t *testing.T) (*sql.DB, *Config) {
+	config := &Config{
+		Host:     os.Getenv("TEST_DB_HOST"),
+		User:     os.Getenv("TEST_DB_USER"),
+		Password: os.Getenv("TEST_DB_PASS"),
+		Name:     os.Getenv("TEST_DB_NAME"),
+	}
+	if config.Host == "" {
+		t.Skip("No test database configured (TEST_DB_HOST)")
+	}
+	schema := fmt.Sprintf("test_%d", time.Now().UnixNano())
+
+	// every connection of all pools uses the schema
+	t.Setenv("PGOPTIONS", "-c search_path="+schema)
+
+	conn, err := sql.Open("postgres", fmt.Sprintf("host=%s user=%s "+
+		"password='%s' dbname=%s sslmode=disable", config.Host, config.User,
+		config.Password, config.Name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.Exec("CREATE SCHEMA " + schema); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		conn.Exec("DROP SCHEMA " + schema + " CASCADE")
+		conn.Close()
+	})
+
+	_, file, _, _ := runtime.Caller(0)
+	script, err := ioutil.ReadFile(filepath.Join(filepath.Dir(file),
+		setup_script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tables := string(script)
+	tables = tables[strings.Index(tables, "CREATE TABLE"):]
+	tables = tables[:strings.Index(tables, "-- Transfer ownership")]
+	if _, err := conn.Exec(tables); err != nil {
+		t.Fatal(err)
+	}
+
+	return conn, config
+}
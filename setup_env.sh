@@ -13,6 +13,22 @@ export SESSION_ENC=
 # File system path where the platform may store persistent files
 # (default: cache)
 export CACHE_PATH=cache
+# Where Git patches and artifacts are stored: "filesystem" (below CACHE_PATH)
+# or "s3" (S3-compatible object storage, see the S3_* variables)
+# (default: filesystem)
+export STORAGE_BACKEND=filesystem
+# Base URL, region and bucket of the S3-compatible object storage
+# (default: --none--, us-east-1, --none--)
+export S3_ENDPOINT=
+export S3_REGION=
+export S3_BUCKET=
+# Credentials used to access the bucket
+# (default: --none--)
+export S3_ACCESS_KEY=
+export S3_SECRET_KEY=
+# Address the bucket via the path instead of the host name?
+# (default: true)
+export S3_PATH_STYLE=true
 # GitHub user name of the person who administrates the platform
 # (default: --none--)
 export ADMIN_USER=
@@ -31,6 +47,20 @@ export APP_SSL_MODE=false
 # Port where the worker interface is exposed
 # (default: 4242)
 export WORKER_PORT=4242
+# PEM encoded certificate and key of the worker interface (TLS is enabled if
+# both are set, send SIGHUP to reload them)
+# (default: --none--)
+export WORKER_TLS_CERT=
+export WORKER_TLS_KEY=
+# PEM encoded CA certificates issuing the client certificates of workers (client
+# certificates are required if set)
+# (default: --none--)
+export WORKER_TLS_CLIENT_CA=
+# Bounds of the timeouts of tasks in seconds (timeouts of bots and actions are
+# kept within these bounds)
+# (default: 60 and 3600)
+export TASK_TIMEOUT_MIN=60
+export TASK_TIMEOUT_MAX=3600
 # Host name where the postgreSQL database is located
 # (default: localhost)
 export DB_HOST=localhost
@@ -0,0 +1,262 @@
+// Store keeping the files in an S3-compatible object storage.
+package storage
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Hex encoded SHA-256 checksum of an empty request body.
+const empty_payload_hash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// Layout of the date and time used for request signatures.
+const (
+	amz_date_layout     = "20060102T150405Z"
+	amz_day_date_layout = "20060102"
+)
+
+// Store that keeps the files as objects of a bucket of an S3-compatible object
+// storage (e.g. Amazon S3 or MinIO). The key of a file is the object's key.
+// Requests are authenticated using AWS Signature Version 4.
+type S3Store struct {
+	endpoint   *url.URL
+	region     string
+	bucket     string
+	access_key string
+	secret_key string
+	path_style bool
+	client     *http.Client
+}
+
+// Instantiate a new store keeping the files in `bucket`. `endpoint` is the
+// base URL of the object storage (e.g. "https://s3.amazonaws.com" or
+// "http://localhost:9000"). If `path_style` is set the bucket is addressed as
+// part of the path (as expected by most S3-compatible object storages) instead
+// of the host name.
+func NewS3Store(endpoint, region, bucket, access_key, secret_key string,
+	path_style bool) (*S3Store, error) {
+	base, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("Invalid S3 endpoint %q!", endpoint)
+	}
+	if bucket == "" {
+		return nil, fmt.Errorf("No S3 bucket specified!")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	return &S3Store{
+		endpoint:   base,
+		region:     region,
+		bucket:     bucket,
+		access_key: access_key,
+		secret_key: secret_key,
+		path_style: path_style,
+		client:     &http.Client{},
+	}, nil
+}
+
+// Uploads the content as object.
+func (s *S3Store) Put(key string, content io.Reader, size int64) error {
+	resp, err := s.do("PUT", key, io.LimitReader(content, size), size)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return checkResponse(resp)
+}
+
+// Downloads the object.
+func (s *S3Store) Get(key string) (io.ReadCloser, error) {
+	resp, err := s.do("GET", key, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// Deletes the object.
+func (s *S3Store) Delete(key string) error {
+	resp, err := s.do("DELETE", key, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := checkResponse(resp); err != nil && err != NotFound {
+		return err
+	}
+
+	return nil
+}
+
+// Helper to send a signed request concerning the object with the given key.
+func (s *S3Store) do(method, key string, body io.Reader, size int64) (
+	*http.Response, error) {
+	if key == "" || strings.HasPrefix(key, "/") {
+		return nil, InvalidKey
+	}
+
+	object := *s.endpoint
+	object.RawQuery = ""
+	path := strings.TrimSuffix(object.Path, "/")
+	if s.path_style {
+		path += "/" + s.bucket
+	} else {
+		object.Host = s.bucket + "." + object.Host
+	}
+	object.Path = path + "/" + key
+	object.RawPath = uriEncode(path, false) + "/" + uriEncode(key, false)
+
+	req, err := http.NewRequest(method, object.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	payload_hash := empty_payload_hash
+	if body != nil {
+		req.ContentLength = size
+		payload_hash = "UNSIGNED-PAYLOAD"
+		// otherwise an empty body is sent using chunked encoding
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payload_hash)
+	s.sign(req, time.Now().UTC())
+
+	return s.client.Do(req)
+}
+
+// Helper to add the signature (AWS Signature Version 4) of the request to its
+// headers. The host and all headers set so far are signed.
+func (s *S3Store) sign(req *http.Request, now time.Time) {
+	amz_date := now.Format(amz_date_layout)
+	day := now.Format(amz_day_date_layout)
+	req.Header.Set("X-Amz-Date", amz_date)
+
+	// canonical headers
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(
+			strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonical_headers string
+	for _, name := range names {
+		canonical_headers += name + ":" + headers[name] + "\n"
+	}
+	signed_headers := strings.Join(names, ";")
+
+	// canonical query string
+	query := req.URL.Query()
+	query_names := make([]string, 0, len(query))
+	for name := range query {
+		query_names = append(query_names, name)
+	}
+	sort.Strings(query_names)
+	var query_parts []string
+	for _, name := range query_names {
+		for _, value := range query[name] {
+			query_parts = append(query_parts,
+				uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+
+	canonical_request := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		strings.Join(query_parts, "&"),
+		canonical_headers,
+		signed_headers,
+		req.Header.Get("X-Amz-Content-Sha256"),
+	}, "\n")
+
+	scope := day + "/" + s.region + "/s3/aws4_request"
+	string_to_sign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amz_date,
+		scope,
+		hashHex([]byte(canonical_request)),
+	}, "\n")
+
+	signing_key := hmacSHA256([]byte("AWS4"+s.secret_key), day)
+	signing_key = hmacSHA256(signing_key, s.region)
+	signing_key = hmacSHA256(signing_key, "s3")
+	signing_key = hmacSHA256(signing_key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signing_key, string_to_sign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 "+
+		"Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.access_key,
+		scope, signed_headers, signature))
+}
+
+// Helper to convert an unsuccessful response into an error.
+func checkResponse(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return NotFound
+	}
+
+	message, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("S3 request failed with status %s: %s", resp.Status,
+		message)
+}
+
+// Helper to URI encode a value as required by AWS Signature Version 4, i.e.
+// all characters except the unreserved ones are percent encoded. Slashes are
+// only encoded if `encode_slash` is set.
+func uriEncode(value string, encode_slash bool) string {
+	var encoded bytes.Buffer
+	for _, b := range []byte(value) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9',
+			b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encode_slash:
+			encoded.WriteByte(b)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", b)
+		}
+	}
+
+	return encoded.String()
+}
+
+// Helper to compute the hex encoded SHA-256 checksum of the data.
+func hashHex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Helper to compute the HMAC-SHA256 of the data using the key.
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
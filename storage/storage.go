@@ -0,0 +1,27 @@
+// Storage of files (e.g. Git patches and artifacts) produced by tasks.
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// A store keeps files identified by keys. Keys are slash separated paths
+// relative to the store's root (e.g. "patches/<name>.patch").
+type Store interface {
+	// Stores `size` bytes read from `content` under the given key. An existing
+	// file with the same key is replaced.
+	Put(key string, content io.Reader, size int64) error
+	// Opens the file stored under the given key. Returns `NotFound` if there
+	// is no such file. The caller must close the returned reader.
+	Get(key string) (io.ReadCloser, error)
+	// Removes the file stored under the given key. Removing a file that does
+	// not exist is not an error.
+	Delete(key string) error
+}
+
+// Custom error messages.
+var (
+	NotFound   = errors.New("The requested file does not exist!")
+	InvalidKey = errors.New("The provided key is not valid!")
+)
@@ -17,7 +17,48 @@ function pad(number, length){
     return str
 }
 
+// Returns the cron expression of the selected periodicity. The times are
+// taken as they are, i.e. they are evaluated in the selected time zone.
+function periodicCron(){
+    var date = new Date(time);
+    if(periodic_sel == 0){
+        return "0_*/"+hour+"_*_*_*";
+    } else if(periodic_sel == 1){
+        return date.getMinutes()+"_"+date.getHours()+"_*_*_*";
+    } else if(periodic_sel == 2){
+        return date.getMinutes()+"_"+date.getHours()+"_*_*_"+weekday;
+    }
+    return "";
+}
+
+// Shows the next execution times of the selected schedule.
+function updatePreview(){
+    var preview = $("#schedule-preview");
+    var cron = periodicCron();
+    if(cron == ""){
+        preview.empty();
+        return;
+    }
+    $.getJSON(preview.data("url"), {
+        cron: cron,
+        timezone: $("#timezone-tab1").val()
+    }).done(function(times){
+        var list = $("<ul></ul>");
+        $.each(times, function(i, next){
+            list.append($("<li></li>").text(next.replace("T", " ")));
+        });
+        preview.empty().append("<label>Next runs</label>").append(list);
+    }).fail(function(response){
+        preview.empty().append($("<p class='text-danger'></p>").text(response.responseText));
+    });
+}
+
 $(function () {
+    try {
+        $("#timezone-tab1").val(Intl.DateTimeFormat().resolvedOptions().timeZone || "UTC");
+    } catch(e) {
+        $("#timezone-tab1").val("UTC");
+    }
     $('#datetimepicker1').datetimepicker({
         defaultDate: Date(),
         format: "HH:mm A"
@@ -87,6 +128,9 @@ $("#disable_scheduling").click(function(){
     $("#type").removeAttr('name');
     $("#name").removeAttr('name');
     $("#cron").removeAttr('name');
+    $("#timezone").removeAttr('name');
+    $("#misfire").removeAttr('name');
+    $("#misfire_limit").removeAttr('name');
 });
 
 $("#datetimepicker1").on("dp.change", function(old_date) {
@@ -161,19 +205,15 @@ $(".full_url").click(function() {
                 alert("Please select the type of periodicity.");
                 return false;
             }
-            if(periodic_sel == 0){
-                cron = "0_*/"+hour+"_*_*_*";
-            } else if(periodic_sel == 1){
-                var date = new Date(time);
-                var date_utc = new Date(date.getTime() + date.getTimezoneOffset() * 60000);
-                cron = date_utc.getMinutes()+"_"+date_utc.getHours()+"_*_*_*";
-            } else if(periodic_sel == 2){
-                var date = new Date(time);
-                var date_utc = new Date(date.getTime() + date.getTimezoneOffset() * 60000);
-                cron = date_utc.getMinutes()+"_"+date_utc.getHours()+"_*_*_"+weekday;
-            }
+            cron = periodicCron();
             $('#cron').attr('name', 'cron');
             $('#cron').val(cron);
+            $('#timezone').attr('name', 'timezone');
+            $('#timezone').val($("#timezone-tab1").val());
+            $('#misfire').attr('name', 'misfire');
+            $('#misfire').val($("#misfire-tab1").val());
+            $('#misfire_limit').attr('name', 'misfire_limit');
+            $('#misfire_limit').val($("#misfire-limit-tab1").val());
 
             $('#time').removeAttr('name');
             $('#type').removeAttr('name');
@@ -188,6 +228,9 @@ $(".full_url").click(function() {
             $("#time").val(date_utc);
 
             $('#cron').removeAttr('name');
+            $('#timezone').removeAttr('name');
+            $('#misfire').removeAttr('name');
+            $('#misfire_limit').removeAttr('name');
             $('#type').removeAttr('name');
         } else if(chosen_tab == 2){
             exec_basis = 5;
@@ -200,6 +243,9 @@ $(".full_url").click(function() {
 
             $('#time').removeAttr('name');
             $('#cron').removeAttr('name');
+            $('#timezone').removeAttr('name');
+            $('#misfire').removeAttr('name');
+            $('#misfire_limit').removeAttr('name');
         }
     } else {
         exec_basis = 4;
@@ -207,7 +253,21 @@ $(".full_url").click(function() {
         $('#time').removeAttr('name');
         $('#type').removeAttr('name');
         $('#cron').removeAttr('name');
+        $('#timezone').removeAttr('name');
+        $('#misfire').removeAttr('name');
+        $('#misfire_limit').removeAttr('name');
     }
     $("#new-task-form").attr("action", base_url);
     $("#execution_type").val(exec_basis+"");
 });
+
+$("#basis, #numberpicker-input, #weekday-sel, #timezone-tab1").change(updatePreview);
+$("#datetimepicker1, #datetimepicker2").on("dp.change", updatePreview);
+
+$("#misfire-tab1").change(function(){
+    if($(this).val() == 2){
+        $("#misfire-limit-div").show();
+    } else {
+        $("#misfire-limit-div").hide();
+    }
+});
@@ -0,0 +1,51 @@
+// Shows the next execution times of the edited schedule.
+function updatePreview(){
+    var preview = $("#schedule-preview");
+    if(preview.length == 0){
+        return;
+    }
+    $.getJSON(preview.data("url"), {
+        cron: $("#cron").val(),
+        timezone: $("#timezone").val()
+    }).done(function(times){
+        var list = $("<ul></ul>");
+        $.each(times, function(i, next){
+            list.append($("<li></li>").text(next.replace("T", " ")));
+        });
+        preview.empty().append("<label>Next runs</label>").append(list);
+    }).fail(function(response){
+        preview.empty().append($("<p class='text-danger'></p>").text(response.responseText));
+    });
+}
+
+$(function () {
+    // the execution time is stored the same way as it is submitted (see
+    // below), i.e. shifted by the time zone offset
+    var picker = $("#datetimepicker");
+    if(picker.length > 0){
+        var stored = new Date(picker.data("time") * 1000);
+        picker.datetimepicker({
+            defaultDate: new Date(stored.getTime() - stored.getTimezoneOffset() * 60000)
+        });
+    }
+    updatePreview();
+});
+
+$("#cron, #timezone").change(updatePreview);
+
+$("#misfire").change(function(){
+    if($(this).val() == 2){
+        $("#misfire-limit-div").show();
+    } else {
+        $("#misfire-limit-div").hide();
+    }
+});
+
+$("#datetimepicker").on("dp.change", function(new_date) {
+    var date = new Date(new_date.date);
+    date.setSeconds(0);
+    var date_utc = date.getTime() + date.getTimezoneOffset() * 60000;
+
+    $("#time").attr("name", "time");
+    $("#time").val(date_utc);
+});
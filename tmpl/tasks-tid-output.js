@@ -0,0 +1,22 @@
+function append_output(e, css_class) {
+    var output = $("#live-output");
+    var follow = output.scrollTop() + output.innerHeight() >= output[0].scrollHeight - 5;
+    $("<span>").addClass(css_class).text(e.data).appendTo(output);
+    if(follow) {
+        output.scrollTop(output[0].scrollHeight);
+    }
+    return;
+}
+if($("#live-output").length > 0 && window.EventSource) {
+    var source = new EventSource($("#live-output").data("url"));
+    source.addEventListener("stdout", function(e) {
+        append_output(e, "");
+    });
+    source.addEventListener("stderr", function(e) {
+        append_output(e, "text-danger");
+    });
+    source.addEventListener("end", function(e) {
+        source.close();
+        location.reload();
+    });
+}
@@ -0,0 +1,435 @@
+// Reference implementation of a worker client. Connects to the worker port of
+// the platform, executes the assigned tasks and reports their results (see
+// `worker.WorkerAPI`).
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/AnalysisBotsPlatform/platform/worker"
+	"io/ioutil"
+	"log"
+	"net/rpc"
+	"net/url"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Version of the worker client. Reported as metadata of every execution.
+const Version = "1.0.0"
+
+// Interval in seconds after which the output of a running bot is forwarded to
+// the platform.
+const output_flush_interval = 1
+
+// Delay in seconds before reconnecting after the connection to the platform
+// was lost.
+const reconnect_delay = 10
+
+// Delay in seconds before asking for a task again after the platform assigned
+// none (e.g. because the worker is draining).
+const no_task_delay = 30
+
+// Name and email address used for the commit of a bot's changes.
+const (
+	patch_author_name  = "Analysis Bots Platform"
+	patch_author_email = "bots@analysisbots.invalid"
+)
+
+// Worker client executing the tasks assigned to the worker identified by
+// `Token`.
+type Client struct {
+	// Address of the platform's worker port (host:port)
+	Address string
+	// TLS configuration used to connect to the worker port (nil if the worker
+	// port does not use TLS)
+	TLS_config *tls.Config
+	Token      string
+	// Labels advertised by the worker (nil keeps the registered labels)
+	Labels   []string
+	Executor Executor
+	// Directory where the projects are cloned to
+	Work_directory string
+	// Number of tasks executed concurrently (default: 1). Must not exceed the
+	// capacity the worker was registered with.
+	Capacity int
+}
+
+// Helper to connect to the worker port.
+func dial(address string, tls_config *tls.Config) (*rpc.Client, error) {
+	if tls_config == nil {
+		return rpc.Dial("tcp", address)
+	}
+
+	conn, err := tls.Dial("tcp", address, tls_config)
+	if err != nil {
+		return nil, err
+	}
+
+	return rpc.NewClient(conn), nil
+}
+
+// Register a new worker for the user whose worker registration token is passed.
+// The worker executes up to `capacity` tasks concurrently. Returns the token of
+// the new worker.
+func Register(address string, tls_config *tls.Config, user_token, name string,
+	shared bool, labels []string, capacity int) (string, error) {
+	conn, err := dial(address, tls_config)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var token string
+	if err := conn.Call("WorkerAPI.RegisterNewWorker", worker.NewWorker{
+		User_token: user_token,
+		Name:       name,
+		Shared:     shared,
+		Labels:     labels,
+		Capacity:   int64(capacity),
+	}, &token); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Execute tasks until `stop` is closed. The currently executed tasks are
+// finished before returning. Reconnects if the connection to the platform is
+// lost.
+func (c *Client) Run(stop <-chan struct{}) error {
+	for {
+		err := c.serve(stop)
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if isServerError(err, worker.InvalidToken) {
+			return err
+		}
+
+		log.Printf("Connection lost (%s), reconnecting in %d seconds", err,
+			reconnect_delay)
+		select {
+		case <-stop:
+			return nil
+		case <-time.After(reconnect_delay * time.Second):
+		}
+	}
+}
+
+// Helper to execute tasks using a single connection to the platform. Each of
+// the worker's slots asks for tasks on its own (see `serveSlot`). Returns if
+// the connection was lost or `stop` was closed.
+func (c *Client) serve(stop <-chan struct{}) error {
+	conn, err := dial(c.Address, c.TLS_config)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var ack bool
+	if c.Labels != nil {
+		err = conn.Call("WorkerAPI.RegisterWorkerWithLabels",
+			worker.Registration{Worker_token: c.Token, Labels: c.Labels}, &ack)
+	} else {
+		err = conn.Call("WorkerAPI.RegisterWorker", c.Token, &ack)
+	}
+	if err != nil {
+		return err
+	}
+
+	// `GetTask` blocks until a task is assigned, unregistering the worker
+	// makes all pending calls return
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-stop:
+			var ack bool
+			conn.Call("WorkerAPI.UnregisterWorker", c.Token, &ack)
+		case <-stopped:
+		}
+	}()
+
+	slots := c.Capacity
+	if slots < 1 {
+		slots = 1
+	}
+	errs := make(chan error, slots)
+	for i := 0; i < slots; i++ {
+		go func() {
+			errs <- c.serveSlot(conn, stop)
+		}()
+	}
+
+	// a lost connection affects all slots, closing it makes the remaining
+	// slots return as well
+	var first_err error
+	for i := 0; i < slots; i++ {
+		if err := <-errs; err != nil && first_err == nil {
+			first_err = err
+			conn.Close()
+		}
+	}
+
+	return first_err
+}
+
+// Helper to execute one task after another. Returns if the connection was lost
+// or `stop` was closed.
+func (c *Client) serveSlot(conn *rpc.Client, stop <-chan struct{}) error {
+	for {
+		var task worker.Task
+		err := conn.Call("WorkerAPI.GetTask", c.Token, &task)
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+		if isServerError(err, worker.NoTask) {
+			select {
+			case <-stop:
+				return nil
+			case <-time.After(no_task_delay * time.Second):
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := c.execute(conn, &task); err != nil {
+			return err
+		}
+	}
+}
+
+// Helper to execute the task and report its result. Only fails if the
+// connection to the platform was lost.
+func (c *Client) execute(conn *rpc.Client, task *worker.Task) error {
+	task.Worker_token = c.Token
+	task.Hostname, _ = os.Hostname()
+	var ack bool
+	if err := conn.Call("WorkerAPI.PublishTaskStarted", task,
+		&ack); err != nil {
+		if isServerError(err, nil) {
+			// e.g. the task was canceled in the meantime
+			log.Printf("Task %d cannot be started: %s", task.Id, err)
+			return nil
+		}
+		return err
+	}
+
+	// stop the bot if the task is canceled or once the task's deadline passed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if !task.Deadline.IsZero() {
+		var stop_at_deadline context.CancelFunc
+		ctx, stop_at_deadline = context.WithDeadline(ctx, task.Deadline)
+		defer stop_at_deadline()
+	}
+
+	canceled := make(chan bool, 1)
+	go func() {
+		var task_canceled bool
+		err := conn.Call("WorkerAPI.WaitForTaskCancelation", task,
+			&task_canceled)
+		// the task is not valid any longer if it was canceled before
+		task_canceled = task_canceled || isServerError(err, worker.NotValidTask)
+		if task_canceled {
+			cancel()
+		}
+		canceled <- task_canceled
+	}()
+
+	// renew the lease until the result is published
+	heartbeats := time.NewTicker(time.Duration(task.Lease_duration) *
+		time.Second / 3)
+	defer heartbeats.Stop()
+	go func() {
+		for {
+			select {
+			case <-heartbeats.C:
+				var ack bool
+				conn.Call("WorkerAPI.Heartbeat", worker.TaskHeartbeat{
+					Worker_token: c.Token,
+					Tid:          task.Id,
+				}, &ack)
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	result := c.run(ctx, conn, task)
+
+	select {
+	case <-ctx.Done():
+		// the task was canceled or timed out, thus no result is expected
+		return nil
+	default:
+	}
+	if err := conn.Call("WorkerAPI.PublishTaskResult", result,
+		&ack); err != nil {
+		if !isServerError(err, nil) {
+			return err
+		}
+		log.Printf("Result of task %d cannot be published: %s", task.Id, err)
+	}
+	<-canceled
+
+	return nil
+}
+
+// Helper to clone the project, run the bot and collect its result.
+func (c *Client) run(ctx context.Context, conn *rpc.Client,
+	task *worker.Task) *worker.Result {
+	result := &worker.Result{
+		Worker_token: c.Token,
+		Tid:          task.Id,
+		Metadata:     c.Executor.Metadata(),
+	}
+	result.Metadata["worker_version"] = Version
+	result.Metadata["platform"] = runtime.GOOS + "/" + runtime.GOARCH
+
+	project_directory, err := ioutil.TempDir(c.Work_directory,
+		fmt.Sprintf("task-%d-", task.Id))
+	if err != nil {
+		result.Infrastructure_failure = true
+		result.Stderr = err.Error()
+		return result
+	}
+	defer os.RemoveAll(project_directory)
+
+	if err := cloneProject(ctx, task, project_directory); err != nil {
+		result.Infrastructure_failure = true
+		result.Stderr = err.Error()
+		return result
+	}
+
+	output := newTaskOutput(conn, c.Token, task.Id)
+	flushed := make(chan struct{})
+	go func() {
+		output.forward(ctx, output_flush_interval*time.Second)
+		close(flushed)
+	}()
+
+	status, err := c.Executor.Execute(ctx, &Execution{
+		Bot:               task.Bot,
+		Project_directory: project_directory,
+		Stdout:            output.writer(stdout_stream),
+		Stderr:            output.writer(stderr_stream),
+	})
+	output.finish()
+	<-flushed
+	result.Stdout, result.Stderr = output.collected()
+	if err != nil {
+		result.Infrastructure_failure = true
+		result.Stderr += err.Error()
+		return result
+	}
+	result.Exit_status = status
+
+	if task.Patch && status == 0 {
+		patch, err := createPatch(ctx, task, project_directory)
+		if err != nil {
+			result.Infrastructure_failure = true
+			result.Stderr += err.Error()
+			return result
+		}
+		result.Patch = patch
+	}
+
+	return result
+}
+
+// Helper to clone the project of the task using its clone token. The token is
+// removed from the clone's configuration afterwards, since the bot has access
+// to the clone.
+func cloneProject(ctx context.Context, task *worker.Task,
+	directory string) error {
+	clone_url, err := url.Parse(task.Clone_url)
+	if err != nil {
+		return err
+	}
+	clone_url.User = url.UserPassword("worker", task.Clone_token)
+
+	cmd := exec.CommandContext(ctx, "git", "clone", "--quiet", "--depth", "1",
+		clone_url.String(), directory)
+	// never ask for credentials
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Project %s cannot be cloned: %s", task.Project,
+			bytes.TrimSpace(out))
+	}
+
+	clone_url.User = nil
+	cmd = exec.CommandContext(ctx, "git", "remote", "set-url", "origin",
+		clone_url.String())
+	cmd.Dir = directory
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("Clone token cannot be removed: %s",
+			bytes.TrimSpace(out))
+	}
+
+	return nil
+}
+
+// Helper to turn the changes the bot made to the project into a Git patch as
+// expected by `git am`. Returns an empty patch if there are no changes.
+func createPatch(ctx context.Context, task *worker.Task,
+	directory string) (string, error) {
+	git := func(arguments ...string) ([]byte, error) {
+		cmd := exec.CommandContext(ctx, "git", arguments...)
+		cmd.Dir = directory
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME="+patch_author_name,
+			"GIT_AUTHOR_EMAIL="+patch_author_email,
+			"GIT_COMMITTER_NAME="+patch_author_name,
+			"GIT_COMMITTER_EMAIL="+patch_author_email)
+		out, err := cmd.Output()
+		if exit_err, ok := err.(*exec.ExitError); ok {
+			err = fmt.Errorf("git %s failed: %s", arguments[0],
+				bytes.TrimSpace(exit_err.Stderr))
+		}
+		return out, err
+	}
+
+	if _, err := git("add", "--all"); err != nil {
+		return "", err
+	}
+	status, err := git("status", "--porcelain")
+	if err != nil {
+		return "", err
+	}
+	if len(bytes.TrimSpace(status)) == 0 {
+		return "", nil
+	}
+	if _, err := git("commit", "--quiet", "--message",
+		fmt.Sprintf("Changes proposed by %s", task.Bot)); err != nil {
+		return "", err
+	}
+	patch, err := git("format-patch", "--stdout", "-1", "HEAD")
+	if err != nil {
+		return "", err
+	}
+
+	return string(patch), nil
+}
+
+// Helper to check whether the error was returned by the platform (in contrast
+// to a lost connection) and equals `expected` (if given).
+func isServerError(err error, expected error) bool {
+	server_err, ok := err.(rpc.ServerError)
+	if !ok {
+		return false
+	}
+
+	return expected == nil || string(server_err) == expected.Error()
+}
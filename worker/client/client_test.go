@@ -0,0 +1,356 @@
+package client
+
+import (
+	"context"
+	"github.com/AnalysisBotsPlatform/platform/worker"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cgi"
+	"net/http/httptest"
+	"net/rpc"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Token of the worker and clone token used by the tests.
+const (
+	test_worker_token = "worker-token"
+	test_clone_token  = "clone-token"
+)
+
+// Helper to run git in the directory. Skips the test if git is not installed.
+func runGit(t *testing.T, directory string, arguments ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", arguments...)
+	cmd.Dir = directory
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test",
+		"GIT_AUTHOR_EMAIL=test@example.invalid", "GIT_COMMITTER_NAME=test",
+		"GIT_COMMITTER_EMAIL=test@example.invalid")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(arguments, " "), err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+// Helper to serve a repository containing the file "README" via Git's smart
+// HTTP protocol. Fetching requires `test_clone_token` as password. Returns the
+// clone URL.
+func newTestRepository(t *testing.T) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	root := t.TempDir()
+	work := filepath.Join(root, "work")
+	if err := os.Mkdir(work, 0755); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "init", "--quiet")
+	if err := ioutil.WriteFile(filepath.Join(work, "README"),
+		[]byte("original\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "README")
+	runGit(t, work, "commit", "--quiet", "--message", "Initial commit")
+	runGit(t, root, "clone", "--quiet", "--bare", work, "project.git")
+
+	backend := &cgi.Handler{
+		Path: filepath.Join(runGit(t, root, "--exec-path"),
+			"git-http-backend"),
+		Env: []string{"GIT_PROJECT_ROOT=" + root, "GIT_HTTP_EXPORT_ALL=1"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter,
+		r *http.Request) {
+		if _, password, _ := r.BasicAuth(); password != test_clone_token {
+			w.Header().Set("WWW-Authenticate", `Basic realm="clone"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		backend.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL + "/project.git"
+}
+
+// Helper to create a task of the bot "owner/bot" on the test repository.
+func newTestTask(t *testing.T) *worker.Task {
+	return &worker.Task{
+		Id:             42,
+		Project:        "owner/project",
+		Bot:            "owner/bot",
+		Clone_url:      newTestRepository(t),
+		Clone_token:    test_clone_token,
+		Lease_duration: 30,
+	}
+}
+
+func TestCloneProjectRemovesToken(t *testing.T) {
+	task := newTestTask(t)
+	directory := filepath.Join(t.TempDir(), "project")
+
+	if err := cloneProject(context.Background(), task, directory); err != nil {
+		t.Fatal(err)
+	}
+	if content, err := ioutil.ReadFile(filepath.Join(directory,
+		"README")); err != nil || string(content) != "original\n" {
+		t.Errorf("README %q (%v), want %q", content, err, "original\n")
+	}
+
+	config, err := ioutil.ReadFile(filepath.Join(directory, ".git", "config"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(config), test_clone_token) {
+		t.Errorf("the configuration contains the clone token:\n%s", config)
+	}
+	if origin := runGit(t, directory, "remote", "get-url",
+		"origin"); origin != task.Clone_url {
+		t.Errorf("origin %q, want %q", origin, task.Clone_url)
+	}
+}
+
+func TestCloneProjectWithWrongToken(t *testing.T) {
+	task := newTestTask(t)
+	task.Clone_token = "wrong"
+
+	err := cloneProject(context.Background(), task,
+		filepath.Join(t.TempDir(), "project"))
+	if err == nil {
+		t.Fatal("cloned without a valid token")
+	}
+	if strings.Contains(err.Error(), "wrong") {
+		t.Errorf("the error reveals the token: %s", err)
+	}
+}
+
+func TestCreatePatch(t *testing.T) {
+	task := newTestTask(t)
+	directory := filepath.Join(t.TempDir(), "project")
+	if err := cloneProject(context.Background(), task, directory); err != nil {
+		t.Fatal(err)
+	}
+
+	patch, err := createPatch(context.Background(), task, directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch != "" {
+		t.Errorf("patch %q without changes", patch)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(directory, "README"),
+		[]byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if patch, err = createPatch(context.Background(), task,
+		directory); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Changes proposed by owner/bot",
+		"-original", "+changed", patch_author_email} {
+		if !strings.Contains(patch, expected) {
+			t.Errorf("patch lacks %q:\n%s", expected, patch)
+		}
+	}
+}
+
+// Stand-in for the worker API of the platform recording the calls concerning
+// the execution of a task.
+type fakePlatform struct {
+	// delivers whether the task is canceled to `WaitForTaskCancelation`
+	cancelation chan bool
+	guard       sync.Mutex
+	started     []worker.Task
+	results     []worker.Result
+}
+
+func (p *fakePlatform) PublishTaskStarted(task worker.Task, ack *bool) error {
+	p.guard.Lock()
+	defer p.guard.Unlock()
+	p.started = append(p.started, task)
+	*ack = true
+	return nil
+}
+
+func (p *fakePlatform) WaitForTaskCancelation(task worker.Task,
+	canceled *bool) error {
+	*canceled = <-p.cancelation
+	return nil
+}
+
+func (p *fakePlatform) PublishTaskResult(result worker.Result,
+	ack *bool) error {
+	p.guard.Lock()
+	defer p.guard.Unlock()
+	p.results = append(p.results, result)
+	*ack = true
+	// the result ends the wait for the cancelation
+	select {
+	case p.cancelation <- false:
+	default:
+	}
+	return nil
+}
+
+func (p *fakePlatform) Heartbeat(beat worker.TaskHeartbeat, ack *bool) error {
+	*ack = true
+	return nil
+}
+
+func (p *fakePlatform) AppendTaskOutput(chunk worker.OutputChunk,
+	ack *bool) error {
+	*ack = true
+	return nil
+}
+
+// Helper to start the fake platform and to connect to it.
+func newFakePlatform(t *testing.T) (*fakePlatform, *rpc.Client) {
+	platform := &fakePlatform{cancelation: make(chan bool, 1)}
+	server := rpc.NewServer()
+	if err := server.RegisterName("WorkerAPI", platform); err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Accept(listener)
+
+	conn, err := rpc.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		// unblock a pending `WaitForTaskCancelation`
+		select {
+		case platform.cancelation <- false:
+		default:
+		}
+		conn.Close()
+		listener.Close()
+	})
+
+	return platform, conn
+}
+
+// Helper to create a client executing the bot "owner/bot" using the script.
+func newTestClient(t *testing.T, script string) *Client {
+	return &Client{
+		Token:          test_worker_token,
+		Executor:       newTestLocalExecutor(t, script),
+		Work_directory: t.TempDir(),
+	}
+}
+
+func TestExecutePublishesResult(t *testing.T) {
+	platform, conn := newFakePlatform(t)
+	client := newTestClient(t, "cat README; echo changed > README; exit 0")
+	task := newTestTask(t)
+	task.Patch = true
+
+	if err := client.execute(conn, task); err != nil {
+		t.Fatal(err)
+	}
+
+	platform.guard.Lock()
+	defer platform.guard.Unlock()
+	if len(platform.started) != 1 ||
+		platform.started[0].Worker_token != test_worker_token {
+		t.Errorf("started %+v, want one call with the worker token",
+			platform.started)
+	}
+	if len(platform.results) != 1 {
+		t.Fatalf("%d results, want 1", len(platform.results))
+	}
+	result := platform.results[0]
+	if result.Worker_token != test_worker_token || result.Tid != task.Id {
+		t.Errorf("result of task %d with token %q, want task %d with %q",
+			result.Tid, result.Worker_token, task.Id, test_worker_token)
+	}
+	if result.Exit_status != 0 || result.Infrastructure_failure {
+		t.Errorf("exit status %d (infrastructure failure: %v), want 0",
+			result.Exit_status, result.Infrastructure_failure)
+	}
+	if result.Stdout != "original\n" {
+		t.Errorf("stdout %q, want %q", result.Stdout, "original\n")
+	}
+	if !strings.Contains(result.Patch, "+changed") {
+		t.Errorf("patch lacks the change:\n%s", result.Patch)
+	}
+	if result.Metadata["executor"] != "local" ||
+		result.Metadata["worker_version"] != Version {
+		t.Errorf("metadata %v lacks the executor and version",
+			result.Metadata)
+	}
+}
+
+func TestExecuteReportsInfrastructureFailure(t *testing.T) {
+	platform, conn := newFakePlatform(t)
+	client := newTestClient(t, "exit 0")
+	task := newTestTask(t)
+	task.Bot = "owner/missing"
+
+	if err := client.execute(conn, task); err != nil {
+		t.Fatal(err)
+	}
+
+	platform.guard.Lock()
+	defer platform.guard.Unlock()
+	if len(platform.results) != 1 ||
+		!platform.results[0].Infrastructure_failure {
+		t.Errorf("results %+v, want an infrastructure failure",
+			platform.results)
+	}
+}
+
+func TestExecuteStopsBotAtDeadline(t *testing.T) {
+	platform, conn := newFakePlatform(t)
+	client := newTestClient(t, "exec sleep 10")
+	task := newTestTask(t)
+	task.Deadline = time.Now().Add(time.Second)
+
+	start := time.Now()
+	if err := client.execute(conn, task); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the bot was not stopped at the deadline")
+	}
+
+	platform.guard.Lock()
+	defer platform.guard.Unlock()
+	if len(platform.results) != 0 {
+		t.Errorf("results %+v published after the deadline",
+			platform.results)
+	}
+}
+
+func TestExecuteStopsCanceledBot(t *testing.T) {
+	platform, conn := newFakePlatform(t)
+	client := newTestClient(t, "exec sleep 10")
+	task := newTestTask(t)
+	platform.cancelation <- true
+
+	start := time.Now()
+	if err := client.execute(conn, task); err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the canceled bot was not stopped")
+	}
+
+	platform.guard.Lock()
+	defer platform.guard.Unlock()
+	if len(platform.results) != 0 {
+		t.Errorf("results %+v published for a canceled task",
+			platform.results)
+	}
+}
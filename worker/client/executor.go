@@ -0,0 +1,153 @@
+// Executors running bots on behalf of the worker client.
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Directory where the project is mounted inside of a bot's container.
+const container_project_directory = "/project"
+
+// Exit status of `docker run` if the container could not be run.
+const docker_run_failure = 125
+
+// Description of a single execution of a bot.
+type Execution struct {
+	// Name of the bot (e.g. the name of its Docker image)
+	Bot string
+	// Directory containing a clone of the project the bot is executed on
+	Project_directory string
+	// Output of the bot
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// An executor runs bots. Implementations decide how bots are isolated from
+// the worker (e.g. in a container).
+type Executor interface {
+	// Runs the bot on the project and returns its exit status. The bot must
+	// be stopped as soon as `ctx` is done. An error is returned only if the
+	// bot could not be executed properly because of a problem of the worker
+	// (e.g. its image could not be pulled). Such executions are retried by the
+	// platform.
+	Execute(ctx context.Context, execution *Execution) (int, error)
+	// Describes the executor. Reported as metadata of every execution.
+	Metadata() map[string]string
+}
+
+// Custom error messages.
+var (
+	BotNotFound = errors.New("The bot cannot be found!")
+)
+
+// Executor running the bots as Docker containers. The project is mounted to
+// /project which is the working directory of the container.
+type DockerExecutor struct {
+	// Docker command (default: "docker")
+	Command string
+	// Additional arguments of `docker run` (e.g. "--memory=1g")
+	Run_arguments []string
+}
+
+// Pulls the bot's image and runs it in a container that is removed afterwards.
+func (e *DockerExecutor) Execute(ctx context.Context,
+	execution *Execution) (int, error) {
+	command := e.Command
+	if command == "" {
+		command = "docker"
+	}
+
+	pull_cmd := exec.CommandContext(ctx, command, "pull", execution.Bot)
+	if out, err := pull_cmd.CombinedOutput(); err != nil {
+		return 0, fmt.Errorf("Image %s cannot be pulled: %s", execution.Bot,
+			bytes.TrimSpace(out))
+	}
+
+	project_directory, err := filepath.Abs(execution.Project_directory)
+	if err != nil {
+		return 0, err
+	}
+	arguments := []string{"run", "--rm",
+		"--volume", project_directory + ":" + container_project_directory,
+		"--workdir", container_project_directory}
+	arguments = append(arguments, e.Run_arguments...)
+	arguments = append(arguments, execution.Bot)
+
+	run_cmd := exec.CommandContext(ctx, command, arguments...)
+	run_cmd.Stdout = execution.Stdout
+	run_cmd.Stderr = execution.Stderr
+
+	return exitStatus(run_cmd.Run(), docker_run_failure)
+}
+
+// Reports the executor's type.
+func (e *DockerExecutor) Metadata() map[string]string {
+	return map[string]string{"executor": "docker"}
+}
+
+// Executor running the bots as local processes without any isolation. Meant
+// for testing bots and the platform. The bot "owner/name" is the executable
+// `<Bots_directory>/owner/name`. It is run in the project's directory.
+type LocalExecutor struct {
+	Bots_directory string
+}
+
+// Runs the bot's executable.
+func (e *LocalExecutor) Execute(ctx context.Context,
+	execution *Execution) (int, error) {
+	bot := filepath.Join(e.Bots_directory, filepath.FromSlash(execution.Bot))
+	if !strings.HasPrefix(bot, filepath.Clean(e.Bots_directory)+
+		string(filepath.Separator)) {
+		return 0, BotNotFound
+	}
+	if _, err := os.Stat(bot); err != nil {
+		return 0, BotNotFound
+	}
+	bot, err := filepath.Abs(bot)
+	if err != nil {
+		return 0, err
+	}
+
+	cmd := exec.CommandContext(ctx, bot)
+	cmd.Dir = execution.Project_directory
+	cmd.Stdout = execution.Stdout
+	cmd.Stderr = execution.Stderr
+
+	return exitStatus(cmd.Run(), -1)
+}
+
+// Reports the executor's type.
+func (e *LocalExecutor) Metadata() map[string]string {
+	return map[string]string{"executor": "local"}
+}
+
+// Helper to convert the error of running a command into its exit status. Exit
+// statuses equal to `failure_status` denote that the command could not be run.
+func exitStatus(err error, failure_status int) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+
+	exit_err, ok := err.(*exec.ExitError)
+	if !ok {
+		return 0, err
+	}
+	status := exit_err.ExitCode()
+	if status < 0 {
+		// killed by a signal, e.g. after the task was canceled
+		return status, nil
+	}
+	if status == failure_status {
+		return 0, err
+	}
+
+	return status, nil
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Helper to create an executable shell script at the path.
+func writeScript(t *testing.T, path, script string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script),
+		0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Helper to create a local executor whose bots directory contains the bot
+// "owner/bot" running the script.
+func newTestLocalExecutor(t *testing.T, script string) *LocalExecutor {
+	bots_directory := t.TempDir()
+	writeScript(t, filepath.Join(bots_directory, "owner", "bot"), script)
+
+	return &LocalExecutor{Bots_directory: bots_directory}
+}
+
+func TestLocalExecutorRunsBot(t *testing.T) {
+	executor := newTestLocalExecutor(t, "echo out; pwd; echo err >&2; exit 3")
+	project_directory, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	status, err := executor.Execute(context.Background(), &Execution{
+		Bot:               "owner/bot",
+		Project_directory: project_directory,
+		Stdout:            &stdout,
+		Stderr:            &stderr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 3 {
+		t.Errorf("exit status %d, want 3", status)
+	}
+	if expected := "out\n" + project_directory + "\n"; stdout.String() !=
+		expected {
+		t.Errorf("stdout %q, want %q", stdout.String(), expected)
+	}
+	if stderr.String() != "err\n" {
+		t.Errorf("stderr %q, want %q", stderr.String(), "err\n")
+	}
+}
+
+func TestLocalExecutorRejectsUnknownBots(t *testing.T) {
+	executor := newTestLocalExecutor(t, "exit 0")
+	outside := filepath.Join(filepath.Dir(executor.Bots_directory), "escape")
+	writeScript(t, outside, "exit 0")
+
+	for _, bot := range []string{"owner/missing", "../escape"} {
+		_, err := executor.Execute(context.Background(), &Execution{
+			Bot:               bot,
+			Project_directory: t.TempDir(),
+			Stdout:            ioutil.Discard,
+			Stderr:            ioutil.Discard,
+		})
+		if err != BotNotFound {
+			t.Errorf("bot %q: got %v, want BotNotFound", bot, err)
+		}
+	}
+}
+
+func TestLocalExecutorStopsBot(t *testing.T) {
+	executor := newTestLocalExecutor(t, "exec sleep 10")
+	ctx, cancel := context.WithTimeout(context.Background(),
+		100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	status, err := executor.Execute(ctx, &Execution{
+		Bot:               "owner/bot",
+		Project_directory: t.TempDir(),
+		Stdout:            ioutil.Discard,
+		Stderr:            ioutil.Discard,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status >= 0 {
+		t.Errorf("exit status %d, want a killed bot", status)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Error("the bot was not stopped")
+	}
+}
+
+// Helper to create a fake Docker command which logs its arguments (one call
+// per line) and runs the script. Returns the command and the log file.
+func newFakeDocker(t *testing.T, script string) (string, string) {
+	directory := t.TempDir()
+	command := filepath.Join(directory, "docker")
+	calls := filepath.Join(directory, "calls")
+	writeScript(t, command, "echo \"$@\" >> "+calls+"\n"+script)
+
+	return command, calls
+}
+
+func TestDockerExecutorRunsContainer(t *testing.T) {
+	command, calls := newFakeDocker(t,
+		"if [ \"$1\" = run ]; then echo out; echo err >&2; exit 2; fi")
+	executor := &DockerExecutor{
+		Command:       command,
+		Run_arguments: []string{"--memory=1g"},
+	}
+	project_directory := t.TempDir()
+
+	var stdout, stderr bytes.Buffer
+	status, err := executor.Execute(context.Background(), &Execution{
+		Bot:               "owner/bot",
+		Project_directory: project_directory,
+		Stdout:            &stdout,
+		Stderr:            &stderr,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status != 2 {
+		t.Errorf("exit status %d, want 2", status)
+	}
+	if stdout.String() != "out\n" || stderr.String() != "err\n" {
+		t.Errorf("output %q and %q, want %q and %q", stdout.String(),
+			stderr.String(), "out\n", "err\n")
+	}
+
+	logged, err := ioutil.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "pull owner/bot\n" +
+		"run --rm --volume " + project_directory + ":/project " +
+		"--workdir /project --memory=1g owner/bot\n"
+	if string(logged) != expected {
+		t.Errorf("calls %q, want %q", logged, expected)
+	}
+}
+
+func TestDockerExecutorReportsFailures(t *testing.T) {
+	for _, script := range []string{
+		// the image cannot be pulled
+		"if [ \"$1\" = pull ]; then echo denied; exit 1; fi",
+		// the container cannot be run
+		"if [ \"$1\" = run ]; then exit 125; fi",
+	} {
+		command, _ := newFakeDocker(t, script)
+		executor := &DockerExecutor{Command: command}
+
+		_, err := executor.Execute(context.Background(), &Execution{
+			Bot:               "owner/bot",
+			Project_directory: t.TempDir(),
+			Stdout:            ioutil.Discard,
+			Stderr:            ioutil.Discard,
+		})
+		if err == nil {
+			t.Errorf("script %q: no error", script)
+		} else if strings.HasPrefix(script, "if [ \"$1\" = pull ]") &&
+			!strings.Contains(err.Error(), "denied") {
+			t.Errorf("script %q: error %q lacks the output", script, err)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Forwarding of the output of running bots.
+package client
+
+import (
+	"bytes"
+	"context"
+	"github.com/AnalysisBotsPlatform/platform/worker"
+	"io"
+	"net/rpc"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Names of the output streams of a bot (see `worker.OutputChunk`).
+const (
+	stdout_stream = "stdout"
+	stderr_stream = "stderr"
+)
+
+// Part of the output that was not forwarded yet.
+type pending_output struct {
+	stream  string
+	content []byte
+}
+
+// Collects the output of a bot. The output is forwarded to the platform in
+// chunks while the bot is running (see `forward`) and reported as part of the
+// result afterwards.
+type taskOutput struct {
+	conn     *rpc.Client
+	token    string
+	tid      int64
+	seq      int64
+	stdout   bytes.Buffer
+	stderr   bytes.Buffer
+	pending  []pending_output
+	finished chan struct{}
+	guard    sync.Mutex
+}
+
+// Writer appending to one of the streams of a `taskOutput`.
+type outputWriter struct {
+	output *taskOutput
+	stream string
+}
+
+// Instantiate the output of the task executed by the worker identified by
+// `token`.
+func newTaskOutput(conn *rpc.Client, token string, tid int64) *taskOutput {
+	return &taskOutput{
+		conn:     conn,
+		token:    token,
+		tid:      tid,
+		finished: make(chan struct{}),
+	}
+}
+
+// Returns a writer appending to the given stream.
+func (o *taskOutput) writer(stream string) io.Writer {
+	return &outputWriter{output: o, stream: stream}
+}
+
+// Appends the data to the stream and marks it for forwarding.
+func (w *outputWriter) Write(data []byte) (int, error) {
+	o := w.output
+	o.guard.Lock()
+	defer o.guard.Unlock()
+
+	if w.stream == stdout_stream {
+		o.stdout.Write(data)
+	} else {
+		o.stderr.Write(data)
+	}
+
+	last := len(o.pending) - 1
+	if last >= 0 && o.pending[last].stream == w.stream {
+		o.pending[last].content = append(o.pending[last].content, data...)
+	} else {
+		o.pending = append(o.pending, pending_output{
+			stream:  w.stream,
+			content: append([]byte(nil), data...),
+		})
+	}
+
+	return len(data), nil
+}
+
+// Forwards the pending output every `interval` until `finish` is called or
+// `ctx` is done. The remaining output is forwarded before returning.
+func (o *taskOutput) forward(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			o.flush(false)
+		case <-o.finished:
+			o.flush(true)
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// Marks the output as complete.
+func (o *taskOutput) finish() {
+	close(o.finished)
+}
+
+// Returns the complete output of both streams.
+func (o *taskOutput) collected() (string, string) {
+	o.guard.Lock()
+	defer o.guard.Unlock()
+
+	return o.stdout.String(), o.stderr.String()
+}
+
+// Helper to send the pending output to the platform. Unless `all` is set,
+// incomplete UTF-8 characters at the end of the output are kept back until
+// the rest of the character was written.
+func (o *taskOutput) flush(all bool) {
+	o.guard.Lock()
+	pending := o.pending
+	o.pending = nil
+	if !all && len(pending) > 0 {
+		last := &pending[len(pending)-1]
+		cut := completePrefix(last.content)
+		if cut < len(last.content) {
+			o.pending = []pending_output{{
+				stream:  last.stream,
+				content: append([]byte(nil), last.content[cut:]...),
+			}}
+			last.content = last.content[:cut]
+		}
+	}
+	o.guard.Unlock()
+
+	for _, output := range pending {
+		if len(output.content) == 0 {
+			continue
+		}
+		var ack bool
+		if err := o.conn.Call("WorkerAPI.AppendTaskOutput", worker.OutputChunk{
+			Worker_token: o.token,
+			Tid:          o.tid,
+			Seq:          o.seq,
+			Stream:       output.stream,
+			Content:      string(output.content),
+		}, &ack); err != nil {
+			// the output is part of the result anyway
+			return
+		}
+		o.seq++
+	}
+}
+
+// Helper to determine the length of the data without an incomplete UTF-8
+// character at its end.
+func completePrefix(data []byte) int {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				return i
+			}
+			break
+		}
+	}
+
+	return len(data)
+}
@@ -0,0 +1,554 @@
+// JSON over HTTP interface of the worker API. Offers the same operations as
+// the RPC interface to worker clients that are not written in Go (see
+// WORKER_API.md for the protocol).
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Version of the HTTP worker API. Part of the path of every endpoint.
+const HTTP_API_version = "v1"
+
+// Default and maximal duration in seconds a long-polling request waits for a
+// task or a cancelation.
+const (
+	default_poll_duration int64 = 30
+	max_poll_duration     int64 = 300
+)
+
+// Maximal size in bytes of a JSON request body.
+const max_request_size int64 = 16 << 20
+
+// Methods of the HTTP worker API. A connection to the worker port starting with
+// one of them carries HTTP requests rather than RPCs.
+var http_methods = [][]byte{[]byte("GET /"), []byte("POST ")}
+
+// Connections to the worker port carrying HTTP requests (see `serveWorker`)
+var http_connections = make(chan net.Conn)
+
+// Custom error messages.
+var (
+	InsecureConnection = errors.New("The worker API is only served on the " +
+		"worker port since TLS is enabled!")
+)
+
+// Connection of a worker client to the worker port. The beginning of the
+// connection is read ahead by `reader` in order to tell RPCs from HTTP
+// requests. `fingerprint` identifies the client certificate presented on the
+// connection (empty if there is none).
+type workerConn struct {
+	net.Conn
+	reader      *bufio.Reader
+	fingerprint string
+}
+
+// Key of the request context storing the `workerConn` an HTTP request arrived
+// on
+type workerConnKey struct{}
+
+// Listener passing the connections of `http_connections` to the HTTP server of
+// the worker port
+type httpConnListener struct {
+	addr net.Addr
+}
+
+// Handler of an HTTP worker API request issued by the worker identified by
+// `worker_token`. `client` serves the request's connection.
+type workerHandler func(http.ResponseWriter, *http.Request, *WorkerAPI,
+	string)
+
+// Payload for registering a new worker client via HTTP. The user's worker
+// registration token is passed in the "Authentication" header.
+type httpNewWorker struct {
+	Name     string
+	Shared   bool
+	Labels   []string
+	Capacity int64
+}
+
+// Payload for marking a worker client as active via HTTP. The labels are only
+// replaced if `Labels` is given.
+type httpRegistration struct {
+	Labels []string
+}
+
+// Payload for marking a task as running via HTTP. `Hostname` is optional.
+type httpTaskStarted struct {
+	Hostname string
+}
+
+// Register the endpoints of the HTTP worker API. `router` must be restricted
+// to the path prefix of the API version (e.g. "/worker/v1"). The endpoints are
+// served on the worker port as well (see `serveHTTPWorkers`). If TLS is enabled
+// on the worker port they refuse requests received elsewhere.
+func RegisterHTTPRoutes(router *mux.Router) {
+	id_regex := "[0-9]+"
+
+	router.HandleFunc("/workers", requireSecureConnection(handleNewWorker)).
+		Methods("POST")
+	router.HandleFunc("/register", makeWorkerHandler(handleRegister)).
+		Methods("POST")
+	router.HandleFunc("/unregister", makeWorkerHandler(handleUnregister)).
+		Methods("POST")
+	router.HandleFunc("/task", makeWorkerHandler(handleGetTask)).
+		Methods("GET")
+	router.HandleFunc(fmt.Sprintf("/tasks/{tid:%s}/started", id_regex),
+		makeWorkerHandler(handleTaskStarted)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/tasks/{tid:%s}/heartbeat", id_regex),
+		makeWorkerHandler(handleHeartbeat)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/tasks/{tid:%s}/output", id_regex),
+		makeWorkerHandler(handleTaskOutput)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/tasks/{tid:%s}/artifacts", id_regex),
+		makeWorkerHandler(handleNewArtifact)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/artifacts/{aid:%s}/data", id_regex),
+		makeWorkerHandler(handleArtifactData)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/artifacts/{aid:%s}/finish", id_regex),
+		makeWorkerHandler(handleFinishArtifact)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/tasks/{tid:%s}/result", id_regex),
+		makeWorkerHandler(handleTaskResult)).Methods("POST")
+	router.HandleFunc(fmt.Sprintf("/tasks/{tid:%s}/cancelation", id_regex),
+		makeWorkerHandler(handleTaskCancelation)).Methods("GET")
+}
+
+// Serves the HTTP worker API on the connections to the worker port that carry
+// HTTP requests. The endpoints are located below "/worker/<version>". Must be
+// called once the worker port is opened.
+func serveHTTPWorkers(listener net.Listener) {
+	router := mux.NewRouter()
+	RegisterHTTPRoutes(router.PathPrefix(fmt.Sprintf("/worker/%s",
+		HTTP_API_version)).Subrouter())
+
+	server := &http.Server{
+		Handler: router,
+		ConnContext: func(ctx context.Context, conn net.Conn) context.Context {
+			return context.WithValue(ctx, workerConnKey{}, conn)
+		},
+	}
+	server.Serve(&httpConnListener{listener.Addr()})
+}
+
+// Returns the next connection carrying HTTP requests.
+func (l *httpConnListener) Accept() (net.Conn, error) {
+	return <-http_connections, nil
+}
+
+// The connections are closed along with the worker port.
+func (l *httpConnListener) Close() error {
+	return nil
+}
+
+// Returns the address of the worker port.
+func (l *httpConnListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Reads the data read ahead first.
+func (c *workerConn) Read(data []byte) (int, error) {
+	return c.reader.Read(data)
+}
+
+// Tells whether the connection carries HTTP requests. Waits until the client
+// sent the beginning of its first request.
+func (c *workerConn) isHTTP() bool {
+	start, _ := c.reader.Peek(len(http_methods[0]))
+	for _, method := range http_methods {
+		if bytes.HasPrefix(start, method) {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Helper functions
+//
+
+// Ensures that the request was received securely, i.e. via the worker port if
+// TLS is enabled on it. Otherwise the client certificates could be bypassed.
+func requireSecureConnection(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tls_guard.RLock()
+		tls_enabled := tls_config != nil
+		tls_guard.RUnlock()
+		_, on_worker_port := r.Context().Value(workerConnKey{}).(*workerConn)
+		if tls_enabled && !on_worker_port {
+			http.Error(w, InsecureConnection.Error(), http.StatusForbidden)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// Ensures that the request carries the token of a known worker in its
+// "Authentication" header.
+func makeWorkerHandler(handler workerHandler) http.HandlerFunc {
+	return requireSecureConnection(func(w http.ResponseWriter,
+		r *http.Request) {
+		client := api.forConnection(requestFingerprint(r))
+		worker_token := r.Header.Get("Authentication")
+		if _, err := client.authenticate(worker_token); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+		handler(w, r, client, worker_token)
+	})
+}
+
+// Returns the fingerprint of the client certificate presented on the request's
+// connection (empty if there is none, e.g. because the request was received on
+// the application port).
+func requestFingerprint(r *http.Request) string {
+	if conn, ok := r.Context().Value(workerConnKey{}).(*workerConn); ok {
+		return conn.fingerprint
+	}
+
+	return ""
+}
+
+// Sends the error with the status code corresponding to it.
+func writeHTTPError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	switch err {
+	case NotValidWorker:
+		status = http.StatusBadRequest
+	case InvalidToken:
+		status = http.StatusUnauthorized
+	case NotPrivileged:
+		status = http.StatusForbidden
+	case NotValidTask, NotValidArtifact:
+		status = http.StatusNotFound
+	case ArtifactTooLarge:
+		status = http.StatusRequestEntityTooLarge
+	case ChecksumMismatch:
+		status = http.StatusUnprocessableEntity
+	}
+
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		fmt.Println(err)
+		message = http.StatusText(status)
+	}
+	http.Error(w, message, status)
+}
+
+// Sends the value as JSON.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// Decodes the JSON request body into `value`. An empty body leaves `value`
+// unchanged.
+func readJSON(r *http.Request, value interface{}) error {
+	err := json.NewDecoder(io.LimitReader(r.Body, max_request_size)).
+		Decode(value)
+	if err == io.EOF {
+		return nil
+	}
+
+	return err
+}
+
+// Extracts the id stored in the path variable `name`.
+func pathId(r *http.Request, name string) int64 {
+	id, _ := strconv.ParseInt(mux.Vars(r)[name], 10, 64)
+	return id
+}
+
+// Derives a context of the request that is done after the number of seconds
+// given by the `wait` query parameter.
+func pollContext(r *http.Request) (context.Context, context.CancelFunc) {
+	wait := default_poll_duration
+	if param := r.URL.Query().Get("wait"); param != "" {
+		if seconds, err := strconv.ParseInt(param, 10, 64); err == nil &&
+			seconds >= 0 {
+			wait = seconds
+		}
+	}
+	if wait > max_poll_duration {
+		wait = max_poll_duration
+	}
+
+	return context.WithTimeout(r.Context(), time.Duration(wait)*time.Second)
+}
+
+//
+// Handler functions
+//
+
+// Registers a new worker client for the user whose worker registration token
+// is passed. Responds with the token of the new worker.
+func handleNewWorker(w http.ResponseWriter, r *http.Request) {
+	client := api.forConnection(requestFingerprint(r))
+	var worker httpNewWorker
+	if err := readJSON(r, &worker); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var token string
+	if err := client.RegisterNewWorker(NewWorker{
+		User_token: r.Header.Get("Authentication"),
+		Name:       worker.Name,
+		Shared:     worker.Shared,
+		Labels:     worker.Labels,
+		Capacity:   worker.Capacity,
+	}, &token); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]string{"Worker_token": token})
+}
+
+// Marks the worker as active.
+func handleRegister(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	var registration httpRegistration
+	if err := readJSON(r, &registration); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ack bool
+	var err error
+	if registration.Labels != nil {
+		err = client.RegisterWorkerWithLabels(Registration{
+			Worker_token: worker_token,
+			Labels:       registration.Labels,
+		}, &ack)
+	} else {
+		err = client.RegisterWorker(worker_token, &ack)
+	}
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Marks the worker as inactive.
+func handleUnregister(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	var ack bool
+	if err := client.UnregisterWorker(worker_token, &ack); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Assigns a pending task to the worker. Waits for a task if there is none.
+// Responds with "No Content" if no task was assigned in time.
+func handleGetTask(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	ctx, cancel := pollContext(r)
+	defer cancel()
+
+	var task Task
+	if err := client.getTask(worker_token, &task, ctx.Done()); err != nil {
+		if err == NoTask {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			writeHTTPError(w, err)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, task)
+}
+
+// Marks the task as running.
+func handleTaskStarted(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	tid := pathId(r, "tid")
+
+	var started httpTaskStarted
+	if err := readJSON(r, &started); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ack bool
+	if err := client.PublishTaskStarted(Task{
+		Worker_token: worker_token,
+		Id:           tid,
+		Hostname:     started.Hostname,
+	}, &ack); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Renews the worker's lease on the task.
+func handleHeartbeat(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	var ack bool
+	if err := client.Heartbeat(TaskHeartbeat{
+		Worker_token: worker_token,
+		Tid:          pathId(r, "tid"),
+	}, &ack); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Stores a chunk of the task's output.
+func handleTaskOutput(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	var chunk OutputChunk
+	if err := readJSON(r, &chunk); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	chunk.Worker_token = worker_token
+	chunk.Tid = pathId(r, "tid")
+
+	var ack bool
+	if err := client.AppendTaskOutput(chunk, &ack); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Starts the upload of an artifact of the task. Responds with the id of the
+// artifact.
+func handleNewArtifact(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	var artifact NewArtifact
+	if err := readJSON(r, &artifact); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	artifact.Worker_token = worker_token
+	artifact.Tid = pathId(r, "tid")
+
+	var aid int64
+	if err := client.CreateArtifact(artifact, &aid); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, map[string]int64{"Id": aid})
+}
+
+// Appends the request body to the artifact. The position of the data within
+// the artifact is given by the `offset` query parameter.
+func handleArtifactData(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	offset, err := strconv.ParseInt(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		http.Error(w, "Invalid offset!", http.StatusBadRequest)
+		return
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, max_artifact_size+1))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var ack bool
+	if err := client.AppendArtifact(ArtifactChunk{
+		Worker_token: worker_token,
+		Artifact:     pathId(r, "aid"),
+		Offset:       offset,
+		Data:         data,
+	}, &ack); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Finishes the upload of the artifact.
+func handleFinishArtifact(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	var checksum ArtifactChecksum
+	if err := readJSON(r, &checksum); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	checksum.Worker_token = worker_token
+	checksum.Artifact = pathId(r, "aid")
+
+	var ack bool
+	if err := client.FinishArtifact(checksum, &ack); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Stores the result of the task.
+func handleTaskResult(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	var result Result
+	if err := readJSON(r, &result); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	result.Worker_token = worker_token
+	result.Tid = pathId(r, "tid")
+
+	var ack bool
+	if err := client.PublishTaskResult(result, &ack); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Waits until the task is canceled or its result is published. Responds with
+// "No Content" if neither happened in time. A task the worker is not executing
+// (any longer) is reported as canceled, since it was taken away from the
+// worker.
+func handleTaskCancelation(w http.ResponseWriter, r *http.Request,
+	client *WorkerAPI, worker_token string) {
+	tid := pathId(r, "tid")
+
+	ctx, cancel := pollContext(r)
+	defer cancel()
+
+	canceled, err := client.waitForTaskCancelation(tid, worker_token,
+		ctx.Done())
+	switch err {
+	case nil:
+	case NotFinished:
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case NotValidTask:
+		canceled = true
+	default:
+		writeHTTPError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]bool{"Canceled": canceled})
+}
@@ -0,0 +1,75 @@
+package worker
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/rpc"
+	"strings"
+	"testing"
+)
+
+// Helper to open a worker port on the loopback interface without TLS. Returns
+// its address.
+func openTestWorkerPort(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	api = NewWorkerAPI()
+	go acceptWorkers(listener)
+	go serveHTTPWorkers(listener)
+
+	return listener.Addr().String()
+}
+
+func TestWorkerPortServesHTTPAndRPC(t *testing.T) {
+	addr := openTestWorkerPort(t)
+
+	// the malformed registration is rejected before touching the database
+	response, err := http.Post("http://"+addr+"/worker/"+HTTP_API_version+
+		"/workers", "application/json", strings.NewReader("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	response.Body.Close()
+	if response.StatusCode != http.StatusBadRequest {
+		t.Errorf("HTTP status %d, want %d", response.StatusCode,
+			http.StatusBadRequest)
+	}
+
+	client, err := rpc.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	var token string
+	err = client.Call("WorkerAPI.RegisterNewWorker",
+		NewWorker{Name: strings.Repeat("x", max_worker_name_length+1)},
+		&token)
+	if err == nil || err.Error() != NotValidWorker.Error() {
+		t.Errorf("RPC error %v, want %v", err, NotValidWorker)
+	}
+}
+
+func TestHTTPAPIRequiresWorkerPortWithTLS(t *testing.T) {
+	tls_guard.Lock()
+	tls_config = &tls.Config{}
+	tls_guard.Unlock()
+	defer func() {
+		tls_guard.Lock()
+		tls_config = nil
+		tls_guard.Unlock()
+	}()
+
+	// e.g. a request received on the application port
+	recorder := httptest.NewRecorder()
+	requireSecureConnection(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("the request was handled")
+	})(recorder, httptest.NewRequest("POST", "/worker/v1/workers", nil))
+	if recorder.Code != http.StatusForbidden {
+		t.Errorf("status %d, want %d", recorder.Code, http.StatusForbidden)
+	}
+}
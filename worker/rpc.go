@@ -2,46 +2,151 @@
 package worker
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/AnalysisBotsPlatform/platform/db"
-	"os"
+	"io"
+	"sort"
 	"strings"
 	"sync"
+	"time"
+	"unicode/utf8"
 )
 
 // This struct stores all relevant information to handle RPS's for the worker
-// API.
+// API. Each connection of a worker client is served by its own `WorkerAPI`
+// (see `forConnection`) sharing the state of all connections.
 type WorkerAPI struct {
-	available_workers map[int64][]waiting_worker
-	shared_workers    []waiting_worker
-	running_workers   map[int64]chan bool
-	guard             *sync.RWMutex
+	*worker_api_state
+	// fingerprint of the client certificate presented on the connection
+	// (empty if there is none)
+	client_fingerprint string
 }
 
-// Payload for registering a new worker client.
+// State shared by the connections of all worker clients.
+type worker_api_state struct {
+	available_workers  map[int64][]waiting_worker
+	shared_workers     []waiting_worker
+	running_workers    map[int64]chan bool
+	guard              *sync.RWMutex
+	output_subscribers map[int64][]chan bool
+	output_guard       *sync.Mutex
+	artifact_locks     map[int64]*artifact_lock
+	artifact_guard     *sync.Mutex
+}
+
+// Lock serializing the uploads of the artifacts of a task. `users` is the
+// number of calls holding or waiting for the lock.
+type artifact_lock struct {
+	sync.Mutex
+	users int
+}
+
+// Payload for registering a new worker client. `Labels` describe the
+// capabilities of the worker (e.g. "arch=arm64", "mem=16g", "net=true"). Only
+// tasks whose bot requires a subset of these labels are assigned to the worker.
+// `Capacity` is the number of tasks the worker executes concurrently (default:
+// 1). Such a worker calls `GetTask` once for each free slot.
 type NewWorker struct {
 	User_token string
 	Name       string
 	Shared     bool
+	Labels     []string
+	Capacity   int64
+}
+
+// Payload for marking a worker client as active while updating the labels it
+// advertises (see `NewWorker`).
+type Registration struct {
+	Worker_token string
+	Labels       []string
 }
 
-// Payload for task assignments.
+// Payload for task assignments. The project is cloned from `Clone_url` using
+// `Clone_token` as password of HTTP basic authentication (the user name is
+// ignored). The token only allows cloning the project and expires as soon as
+// the task ends. The task times out unless its result is published before
+// `Deadline`. The worker may report the name of the host it runs on as
+// `Hostname` when publishing that the task started. When passing the task back
+// (e.g. to `PublishTaskStarted`) the worker must set `Worker_token` to its
+// token. The token is not part of the task's JSON representation, since the
+// HTTP interface passes it as header.
 type Task struct {
-	Id       int64
-	Project  string
-	Bot      string
-	GH_token string
-	Patch    bool
+	Worker_token   string `json:"-"`
+	Id             int64
+	Project        string
+	Bot            string
+	Clone_url      string
+	Clone_token    string
+	Patch          bool
+	Lease_duration int64
+	Deadline       time.Time
+	Hostname       string
+}
+
+// Payload for renewing the lease on a task.
+type TaskHeartbeat struct {
+	Worker_token string
+	Tid          int64
+}
+
+// Payload for streaming the output of a task while it is executed. `Seq` must
+// start at 0 and be incremented for every chunk of the task. Chunks that are
+// sent more than once (e.g. after a connection problem) are stored only once.
+// `Stream` is either "stdout" or "stderr".
+type OutputChunk struct {
+	Worker_token string
+	Tid          int64
+	Seq          int64
+	Stream       string
+	Content      string
 }
 
-// Payload for returning task results.
+// Payload for starting the upload of an artifact (i.e. a file produced by the
+// bot such as a report). `Name` must be unique among the artifacts of the
+// task. Artifacts must be uploaded before the task's result is published.
+type NewArtifact struct {
+	Worker_token string
+	Tid          int64
+	Name         string
+	Content_type string
+}
+
+// Payload for uploading a part of an artifact. `Offset` is the position of
+// `Data` within the artifact, i.e. the number of bytes sent before. Parts that
+// were already received (e.g. after a connection problem) are ignored.
+type ArtifactChunk struct {
+	Worker_token string
+	Artifact     int64
+	Offset       int64
+	Data         []byte
+}
+
+// Payload for finishing the upload of an artifact. `Sha256` is the hex encoded
+// SHA-256 checksum of the artifact's content.
+type ArtifactChecksum struct {
+	Worker_token string
+	Artifact     int64
+	Sha256       string
+}
+
+// Payload for returning task results. `Infrastructure_failure` must be set if
+// the bot could not be executed properly because of a problem on the worker
+// (e.g. the bot's image could not be pulled) rather than the bot itself. Such
+// executions are retried automatically. `Metadata` may describe the execution
+// environment (e.g. the worker's version or the digest of the bot's image).
 type Result struct {
-	Tid         int64
-	Stdout      string
-	Stderr      string
-	Exit_status int
-	Patch       string
+	Worker_token           string
+	Tid                    int64
+	Stdout                 string
+	Stderr                 string
+	Exit_status            int
+	Patch                  string
+	Infrastructure_failure bool
+	Metadata               map[string]string
 }
 
 // Enable a worker to wait for a new task by adding a channel that delivers the
@@ -53,81 +158,315 @@ type waiting_worker struct {
 
 // Custom error messages.
 var (
-	InvalidToken  = errors.New("The provided token is not valid!")
-	NoTask        = errors.New("No task assigned!")
-	NotPrivileged = errors.New("Only admins can register shared workers!")
-	NotValidTask  = errors.New("The provided task is not valid!")
+	InvalidToken     = errors.New("The provided token is not valid!")
+	NoTask           = errors.New("No task assigned!")
+	NotPrivileged    = errors.New("Only admins can register shared workers!")
+	NotValidWorker   = errors.New("The worker's name or labels are too long!")
+	NotValidTask     = errors.New("The provided task is not valid!")
+	NotValidArtifact = errors.New("The provided artifact is not valid!")
+	NotFinished      = errors.New("The task is still being executed!")
+	ArtifactTooLarge = errors.New("The artifact exceeds the size limit!")
+	ChecksumMismatch = errors.New("The artifact's checksum does not match!")
 )
 
 // Instantiate a new remote API for worker clients.
 func NewWorkerAPI() *WorkerAPI {
 	return &WorkerAPI{
-		available_workers: make(map[int64][]waiting_worker),
-		shared_workers:    make([]waiting_worker, 0),
-		running_workers:   make(map[int64]chan bool),
-		guard:             &sync.RWMutex{},
+		worker_api_state: &worker_api_state{
+			available_workers:  make(map[int64][]waiting_worker),
+			shared_workers:     make([]waiting_worker, 0),
+			running_workers:    make(map[int64]chan bool),
+			guard:              &sync.RWMutex{},
+			output_subscribers: make(map[int64][]chan bool),
+			output_guard:       &sync.Mutex{},
+			artifact_locks:     make(map[int64]*artifact_lock),
+			artifact_guard:     &sync.Mutex{},
+		},
+	}
+}
+
+// Derive the API serving a connection on which the client certificate with the
+// given fingerprint was presented.
+func (api *WorkerAPI) forConnection(client_fingerprint string) *WorkerAPI {
+	return &WorkerAPI{
+		worker_api_state:   api.worker_api_state,
+		client_fingerprint: client_fingerprint,
 	}
 }
 
+// Helper to look up the worker identified by the token. Workers bound to a
+// client certificate are only accepted on connections presenting this
+// certificate.
+func (api *WorkerAPI) authenticate(worker_token string) (*db.Worker, error) {
+	worker, err := db.GetWorker(worker_token)
+	if err != nil {
+		return nil, InvalidToken
+	}
+	if worker.Cert_fingerprint != "" &&
+		worker.Cert_fingerprint != api.client_fingerprint {
+		return nil, InvalidToken
+	}
+
+	return worker, nil
+}
+
+// Helper to check that the worker (identified by its token) may report on the
+// task, i.e. it holds the lease on the task.
+func (api *WorkerAPI) authorizeTask(tid int64, worker_token string) error {
+	if _, err := api.authenticate(worker_token); err != nil {
+		return err
+	}
+	if !db.HoldsTaskLease(tid, worker_token) {
+		return NotValidTask
+	}
+
+	return nil
+}
+
 // Assign an available worker to the new task. If there is no worker available
-// the task is not assigned. The assignment is done in two phases:
+// the task is not assigned.
+func (api *WorkerAPI) assignTask(task *db.Task) {
+	api.guard.Lock()
+	defer api.guard.Unlock()
+
+	api.dispatchTask(task)
+}
+
+// Assign an available worker to the task unless the task was picked up or
+// canceled in the meantime or waits for the running execution of its group
+// (see `db.IsTaskAssignable`).
+func (api *WorkerAPI) assignPendingTask(task *db.Task) {
+	api.guard.Lock()
+	defer api.guard.Unlock()
+
+	if db.IsTaskAssignable(task.Id) {
+		api.dispatchTask(task)
+	}
+}
+
+// Helper to hand the task to an available worker. Must be called while holding
+// the guard. Only workers advertising the labels required by the task's bot and
+// permitted by the task's routing rule are considered. The assignment is done
+// in two phases:
 //
 // 1. Try to find a worker belonging to the user that started the task.
 //
 // 2. Only if there is no such worker try to find a shared worker that can
 // execute the task.
-func (api *WorkerAPI) assignTask(task *db.Task) {
-	api.guard.Lock()
-	defer api.guard.Unlock()
+func (api *WorkerAPI) dispatchTask(task *db.Task) {
+	waiting := api.available_workers[task.User.Id]
+	if i := findMatchingWorker(waiting, task); i >= 0 {
+		waiting[i].task_assignment <- task
+		api.available_workers[task.User.Id] = append(waiting[:i],
+			waiting[i+1:]...)
+	} else if i := findMatchingWorker(api.shared_workers, task); i >= 0 {
+		api.shared_workers[i].task_assignment <- task
+		api.shared_workers = append(api.shared_workers[:i],
+			api.shared_workers[i+1:]...)
+	}
+}
 
-	waiting, ok := api.available_workers[task.User.Id]
-	if ok {
-		if len(waiting) > 0 {
-			waiting[0].task_assignment <- task
-			waiting = waiting[1:]
-			api.available_workers[task.User.Id] = waiting
-		}
-	} else {
-		if len(api.shared_workers) > 0 {
-			api.shared_workers[0].task_assignment <- task
-			api.shared_workers = api.shared_workers[1:]
+// Helper to find the first of the `waiting` workers that is able and permitted
+// to execute the task. Returns the worker's index or -1 if there is no such
+// worker.
+func findMatchingWorker(waiting []waiting_worker, task *db.Task) int {
+	for i, ww := range waiting {
+		if ww.worker.Satisfies(task.Bot.Requirements) &&
+			task.Routing.Permits(ww.worker, task.User.Id) {
+			return i
 		}
 	}
+
+	return -1
 }
 
 // Cancel the task specified by `tid`, i.e. send an cancel signal to the worker
 // executing the task (if applicable) and update the task's status to canceled.
-func (api *WorkerAPI) cancelTask(tid int64) {
+// The `reason` and the `actor` are recorded on the task.
+func (api *WorkerAPI) cancelTask(tid int64, reason, actor string) {
+	api.guard.Lock()
+	defer api.guard.Unlock()
+
+	if cancel, ok := api.running_workers[tid]; ok {
+		// the signal is dropped if the worker was already signaled (e.g. its
+		// result is being published)
+		select {
+		case cancel <- true:
+		default:
+		}
+		delete(api.running_workers, tid)
+	}
+	db.CancelTask(tid, reason, actor)
+	db.ReleaseTaskLease(tid)
+	api.notifyOutputSubscribers(tid)
+}
+
+// Helper to take the task away from the worker executing it.
+func (api *WorkerAPI) stopTask(tid int64) {
 	api.guard.Lock()
 	defer api.guard.Unlock()
 
-	// NOTE reason whether this defer call is needed or not
-	defer func() {
-		recover()
-		db.UpdateTaskStatus(tid, db.Canceled)
-	}()
 	if cancel, ok := api.running_workers[tid]; ok {
-		cancel <- true
+		// stop the worker or unblock a pending `WaitForTaskCancelation` of a
+		// lost worker
+		select {
+		case cancel <- true:
+		default:
+		}
 		delete(api.running_workers, tid)
 	}
-	db.UpdateTaskStatus(tid, db.Canceled)
+}
+
+// Take the task away from the worker executing it and mark it as failed
+// because of an infrastructure problem, since the worker rather than the bot
+// failed. The task is retried unless its retry limit is exhausted (see
+// `retryTask`).
+func (api *WorkerAPI) failOrphanedTask(tid int64) {
+	api.stopTask(tid)
+
+	task, err := db.FailOrphanedTask(tid, "The worker stopped responding.")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	api.notifyOutputSubscribers(tid)
+
+	retryTask(task)
+	dispatchQueuedTask(tid)
+}
+
+// Take the task away from the worker executing it and mark it as timed out.
+// `reason` is stored as the task's failure reason. The task is retried unless
+// its retry limit is exhausted (see `retryTask`).
+func (api *WorkerAPI) timeOutTask(tid int64, reason string) {
+	api.stopTask(tid)
+
+	task, err := db.TimeOutTask(tid, reason)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	api.notifyOutputSubscribers(tid)
+
+	retryTask(task)
+	dispatchQueuedTask(tid)
+}
+
+// Register a subscriber for the output of the task. The returned channel
+// receives a value whenever new output of the task is available or the task
+// finished.
+func (api *WorkerAPI) subscribeOutput(tid int64) chan bool {
+	api.output_guard.Lock()
+	defer api.output_guard.Unlock()
+
+	subscription := make(chan bool, 1)
+	api.output_subscribers[tid] = append(api.output_subscribers[tid],
+		subscription)
+
+	return subscription
+}
+
+// Remove a subscriber registered via `subscribeOutput`.
+func (api *WorkerAPI) unsubscribeOutput(tid int64, subscription chan bool) {
+	api.output_guard.Lock()
+	defer api.output_guard.Unlock()
+
+	subscribers := api.output_subscribers[tid]
+	for i, s := range subscribers {
+		if s == subscription {
+			subscribers = append(subscribers[:i], subscribers[i+1:]...)
+			break
+		}
+	}
+	if len(subscribers) == 0 {
+		delete(api.output_subscribers, tid)
+	} else {
+		api.output_subscribers[tid] = subscribers
+	}
+}
+
+// Notify all subscribers of the task's output. Subscribers that were not
+// able to handle the previous notification yet are not notified twice.
+func (api *WorkerAPI) notifyOutputSubscribers(tid int64) {
+	api.output_guard.Lock()
+	defer api.output_guard.Unlock()
+
+	for _, subscription := range api.output_subscribers[tid] {
+		select {
+		case subscription <- true:
+		default:
+		}
+	}
+}
+
+// Take the task away from the worker whose lease on it expired (see
+// `failOrphanedTask`). The silent worker is marked inactive.
+func (api *WorkerAPI) reclaimTask(lease *db.Lease) {
+	db.SetSilentWorkerInactive(lease.Wid, lease_duration)
+	api.failOrphanedTask(lease.Tid)
+	db.DeleteDrainedWorker(lease.Worker_token)
+}
+
+// Stop assigning tasks to the draining worker. A pending `GetTask` of the
+// worker returns `NoTask`.
+func (api *WorkerAPI) drainWorker(worker_token string) {
+	worker, err := db.GetWorker(worker_token)
+	if err != nil {
+		return
+	}
+
+	api.guard.Lock()
+	defer api.guard.Unlock()
+
+	api.releaseAvailableWorker(worker)
 }
 
 // Register a new worker client for the user whose worker registration token is
 // passed.
 func (api *WorkerAPI) RegisterNewWorker(worker NewWorker, token *string) error {
-	tok, err := db.CreateWorker(worker.User_token, worker.Name, worker.Shared)
+	if len(worker.Name) > max_worker_name_length ||
+		!validWorkerLabels(worker.Labels) {
+		return NotValidWorker
+	}
+	capacity := worker.Capacity
+	if capacity < 1 {
+		capacity = 1
+	}
+	tok, err := db.CreateWorker(worker.User_token, worker.Name, worker.Shared,
+		worker.Labels, api.client_fingerprint, capacity)
 	if err != nil { // NOTE handle invalid token and not privileged
-		err = InvalidToken
+		return InvalidToken
 	}
 	*token = tok
 
-	return err
+	return nil
+}
+
+// Helper to check that each of the labels fits into the `workers` table.
+func validWorkerLabels(labels []string) bool {
+	for _, label := range labels {
+		if len(label) > max_worker_label_length {
+			return false
+		}
+	}
+	return true
 }
 
 // Marks the given worker as active. Must be called before any attempt to
-// execute tasks.
+// execute tasks. A worker that is not bound to a client certificate yet is
+// bound to the certificate presented on the connection (if any).
 func (api *WorkerAPI) RegisterWorker(worker string, ack *bool) error {
+	*ack = false
+	if _, err := api.authenticate(worker); err != nil {
+		return err
+	}
+	if api.client_fingerprint != "" {
+		if err := db.BindWorkerCertificate(worker,
+			api.client_fingerprint); err != nil {
+			return InvalidToken
+		}
+	}
+
 	err := db.SetWorkerActive(worker)
 	*ack = err == nil
 	if err != nil {
@@ -137,14 +476,48 @@ func (api *WorkerAPI) RegisterWorker(worker string, ack *bool) error {
 	return err
 }
 
+// Same as `RegisterWorker` but additionally replaces the labels the worker
+// advertises. Allows a worker client to announce changed capabilities (e.g.
+// after a hardware upgrade) without registering a new worker.
+func (api *WorkerAPI) RegisterWorkerWithLabels(registration Registration,
+	ack *bool) error {
+	if _, err := api.authenticate(registration.Worker_token); err != nil {
+		*ack = false
+		return err
+	}
+	if !validWorkerLabels(registration.Labels) {
+		*ack = false
+		return NotValidWorker
+	}
+	if err := db.SetWorkerLabels(registration.Worker_token,
+		registration.Labels); err != nil {
+		*ack = false
+		return InvalidToken
+	}
+
+	return api.RegisterWorker(registration.Worker_token, ack)
+}
+
 // Marks the given worker as inactive and removes it from the set of available
 // workers. Must be called before the worker client terminates.
 func (api *WorkerAPI) UnregisterWorker(worker_token string, ack *bool) error {
+	if _, err := api.authenticate(worker_token); err != nil {
+		*ack = false
+		return err
+	}
+
+	return api.unregisterWorker(worker_token, ack)
+}
+
+// Same as `UnregisterWorker` but without checking the connection's client
+// certificate. Used when a user deletes a worker.
+func (api *WorkerAPI) unregisterWorker(worker_token string, ack *bool) error {
 	err := db.SetWorkerInactive(worker_token)
 	*ack = err == nil
 	if err != nil {
 		return InvalidToken
 	}
+	defer db.DeleteDrainedWorker(worker_token)
 
 	worker, err := db.GetWorker(worker_token)
 	*ack = err == nil
@@ -155,36 +528,18 @@ func (api *WorkerAPI) UnregisterWorker(worker_token string, ack *bool) error {
 	api.guard.Lock()
 	defer api.guard.Unlock()
 
-	waiting, ok := api.available_workers[worker.Uid]
-	if ok {
-		for i, ww := range waiting {
-			if ww.worker.Id == worker.Id {
-				waiting = append(waiting[:i], waiting[i+1:]...)
-				ww.task_assignment <- nil
-				break
-			}
-		}
-		api.available_workers[worker.Uid] = waiting
-	} else {
-		for i, ww := range api.shared_workers {
-			if ww.worker.Id == worker.Id {
-				api.shared_workers = append(api.shared_workers[:i],
-					api.shared_workers[i+1:]...)
-				ww.task_assignment <- nil
-				break
-			}
-		}
-	}
+	api.releaseAvailableWorker(worker)
 
 	return nil
 }
 
 // Helper to add a worker to the set of available workers. Creates a
-// `waiting_worker` and inserts it into the set.
+// `waiting_worker` and inserts it into the set. The channel of the
+// `waiting_worker` is buffered such that assigning a task never blocks.
 func (api *WorkerAPI) addAvailableWorker(worker *db.Worker) waiting_worker {
 	waiting := waiting_worker{
 		worker:          worker,
-		task_assignment: make(chan *db.Task),
+		task_assignment: make(chan *db.Task, 1),
 	}
 
 	if worker.Shared {
@@ -197,19 +552,104 @@ func (api *WorkerAPI) addAvailableWorker(worker *db.Worker) waiting_worker {
 	return waiting
 }
 
+// Helper to remove a worker from the set of available workers. If
+// `assignment` is given only the `waiting_worker` with this channel is removed.
+// Otherwise any `waiting_worker` of the worker is removed. Returns false if
+// there is no such `waiting_worker` (any longer). Must be called while holding
+// the guard.
+func (api *WorkerAPI) removeAvailableWorker(worker *db.Worker,
+	assignment chan *db.Task) (waiting_worker, bool) {
+	waiting := api.shared_workers
+	if !worker.Shared {
+		waiting = api.available_workers[worker.Uid]
+	}
+
+	for i, ww := range waiting {
+		if ww.worker.Id == worker.Id &&
+			(assignment == nil || ww.task_assignment == assignment) {
+			waiting = append(waiting[:i], waiting[i+1:]...)
+			if worker.Shared {
+				api.shared_workers = waiting
+			} else {
+				api.available_workers[worker.Uid] = waiting
+			}
+			return ww, true
+		}
+	}
+
+	return waiting_worker{}, false
+}
+
+// Helper to remove all `waiting_worker`s of the worker from the set of
+// available workers. Their pending `GetTask` calls return `NoTask`. Must be
+// called while holding the guard.
+func (api *WorkerAPI) releaseAvailableWorker(worker *db.Worker) {
+	for {
+		ww, ok := api.removeAvailableWorker(worker, nil)
+		if !ok {
+			break
+		}
+		ww.task_assignment <- nil
+	}
+}
+
+// Helper to count the `waiting_worker`s of the worker, i.e. the number of its
+// slots that wait for a task. Must be called while holding the guard.
+func (api *WorkerAPI) countAvailableWorker(worker *db.Worker) int64 {
+	waiting := api.shared_workers
+	if !worker.Shared {
+		waiting = api.available_workers[worker.Uid]
+	}
+
+	var count int64
+	for _, ww := range waiting {
+		if ww.worker.Id == worker.Id {
+			count++
+		}
+	}
+
+	return count
+}
+
 // Assign a pending task to the calling worker client. Blocks if there is no
 // pending task. Continues execution after a new task was created and assigned
 // to this worker.
 func (api *WorkerAPI) GetTask(worker_token string, task *Task) error {
-	worker, err := db.GetWorker(worker_token)
+	return api.getTask(worker_token, task, nil)
+}
+
+// Same as `GetTask` but stops waiting for a task as soon as `abort` is closed.
+// In this case `NoTask` is returned.
+func (api *WorkerAPI) getTask(worker_token string, task *Task,
+	abort <-chan struct{}) error {
+	worker, err := api.authenticate(worker_token)
 	if err != nil {
 		task = nil
-		return InvalidToken
+		return err
 	}
 
 	api.guard.Lock()
 	defer api.guard.Unlock()
-	pending, err := db.GetPendingTask(worker.Uid, worker.Shared)
+
+	// checked while holding the guard, since draining the worker (see
+	// `drainWorker`) only releases the slots that already wait for a task
+	if db.IsWorkerDraining(worker.Id) {
+		db.DeleteDrainedWorker(worker_token)
+		return NoTask
+	}
+
+	// every slot of the worker is either executing a task or waiting for one,
+	// the leases are counted again since another slot may have acquired one
+	// before the guard was taken
+	occupied, err := db.CountWorkerLeases(worker.Id)
+	if err != nil {
+		return err
+	}
+	if occupied+api.countAvailableWorker(worker) >= worker.Capacity {
+		return NoTask
+	}
+
+	pending, err := db.GetPendingTask(worker)
 	if err != nil {
 		task = nil
 		return err
@@ -218,57 +658,313 @@ func (api *WorkerAPI) GetTask(worker_token string, task *Task) error {
 	if pending == nil {
 		waiting := api.addAvailableWorker(worker)
 		api.guard.Unlock()
-		pending = <-waiting.task_assignment
-		api.guard.Lock()
+		select {
+		case pending = <-waiting.task_assignment:
+			api.guard.Lock()
+		case <-abort:
+			api.guard.Lock()
+			_, ok := api.removeAvailableWorker(worker, waiting.task_assignment)
+			if !ok {
+				// a task was assigned in the meantime
+				pending = <-waiting.task_assignment
+			}
+		}
 		if pending == nil {
 			return NoTask
 		}
 	}
 
+	clone_token, err := db.CreateCloneToken(pending.Id)
+	if err != nil {
+		// the task stays pending and is assigned on the next request
+		fmt.Println(err)
+		return err
+	}
+
 	task.Id = pending.Id
 	task.Project = pending.Project.Name
 	task.Bot = pending.Bot.Name
-	task.GH_token = pending.User.Token
+	task.Clone_url = fmt.Sprintf("%s/%d.git", clone_base_url, pending.Id)
+	task.Clone_token = clone_token
 	for _, tag := range pending.Bot.Tags {
 		if strings.ToLower(tag) == "git patch" {
 			task.Patch = true
 		}
 	}
 
+	task.Lease_duration = lease_duration
+	task.Deadline, err = db.SetTaskDeadline(task.Id, taskTimeout(pending.Gid))
+	if err != nil {
+		fmt.Println(err)
+		return err
+	}
+
+	// without a lease the task would never be reclaimed if the worker fails,
+	// thus it stays pending and is handed to another worker
+	if err := db.AcquireTaskLease(task.Id, worker.Id,
+		lease_duration); err != nil {
+		fmt.Println(err)
+		api.dispatchTask(pending)
+		return err
+	}
 	api.running_workers[task.Id] = make(chan bool, 1)
 	db.UpdateTaskStatus(task.Id, db.Scheduled)
+	db.SetTaskWorker(task.Id, worker.Id)
+	db.RecordExecution(task.Id, worker.Id)
+
+	return nil
+}
+
+// Renew the lease on a task the calling worker is executing. Must be called at
+// least every `Task.Lease_duration` seconds until the task's result is
+// published. Otherwise the task is taken away from the worker and assigned
+// again.
+func (api *WorkerAPI) Heartbeat(beat TaskHeartbeat, ack *bool) error {
+	if _, err := api.authenticate(beat.Worker_token); err != nil {
+		*ack = false
+		return err
+	}
+
+	err := db.RenewTaskLease(beat.Tid, beat.Worker_token, lease_duration)
+	*ack = err == nil
+	if err != nil {
+		return NotValidTask
+	}
+
+	return nil
+}
+
+// Store a chunk of the output of a task the calling worker is executing and
+// forward it to the clients following the task's output.
+func (api *WorkerAPI) AppendTaskOutput(chunk OutputChunk, ack *bool) error {
+	if _, err := api.authenticate(chunk.Worker_token); err != nil {
+		*ack = false
+		return err
+	}
+
+	err := db.AppendTaskOutput(chunk.Worker_token, &db.OutputChunk{
+		Tid:     chunk.Tid,
+		Seq:     chunk.Seq,
+		Stream:  chunk.Stream,
+		Content: chunk.Content,
+	})
+	*ack = err == nil
+	if err != nil {
+		return NotValidTask
+	}
+	api.notifyOutputSubscribers(chunk.Tid)
+
+	return nil
+}
+
+// Start the upload of an artifact of a task the calling worker is executing.
+// Returns the id of the artifact that must be passed along with its content.
+func (api *WorkerAPI) CreateArtifact(artifact NewArtifact, aid *int64) error {
+	if _, err := api.authenticate(artifact.Worker_token); err != nil {
+		return err
+	}
+	content_type := artifact.Content_type
+	if content_type == "" {
+		content_type = "application/octet-stream"
+	}
+	if artifact.Name == "" || len(artifact.Name) > max_artifact_name_length ||
+		len(content_type) > max_content_type_length {
+		return NotValidArtifact
+	}
+
+	created, err := db.CreateArtifact(artifact.Worker_token, artifact.Tid,
+		artifact.Name, content_type)
+	if err != nil {
+		return NotValidArtifact
+	}
+	*aid = created.Id
+
+	return nil
+}
+
+// Append a part of the artifact's content. The part is stored as separate file
+// in the storage until the upload is finished.
+func (api *WorkerAPI) AppendArtifact(chunk ArtifactChunk, ack *bool) error {
+	*ack = false
+	if _, err := api.authenticate(chunk.Worker_token); err != nil {
+		return err
+	}
+	artifact, err := api.lockUploadingArtifact(chunk.Worker_token,
+		chunk.Artifact)
+	if err != nil {
+		return NotValidArtifact
+	}
+	defer api.unlockArtifacts(artifact.Tid)
+
+	// ignore parts that were already received
+	if chunk.Offset+int64(len(chunk.Data)) <= artifact.Size {
+		*ack = true
+		return nil
+	}
+	if chunk.Offset != artifact.Size {
+		return NotValidArtifact
+	}
+	if artifact.Size+int64(len(chunk.Data)) > max_artifact_size {
+		return ArtifactTooLarge
+	}
+
+	if err := store.Put(artifactPartKey(artifact.File_name, artifact.Parts),
+		bytes.NewReader(chunk.Data), int64(len(chunk.Data))); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	db.UpdateArtifactSize(artifact.Id, artifact.Size+int64(len(chunk.Data)),
+		artifact.Parts+1)
+	*ack = true
 
 	return nil
 }
 
+// Finish the upload of the artifact. Its parts are joined into a single file of
+// the storage while computing the checksum of the content. The artifact is
+// discarded if the checksum does not match.
+func (api *WorkerAPI) FinishArtifact(checksum ArtifactChecksum,
+	ack *bool) error {
+	*ack = false
+	if _, err := api.authenticate(checksum.Worker_token); err != nil {
+		return err
+	}
+	artifact, err := api.lockUploadingArtifact(checksum.Worker_token,
+		checksum.Artifact)
+	if err != nil {
+		return NotValidArtifact
+	}
+	defer api.unlockArtifacts(artifact.Tid)
+
+	parts := &partsReader{artifact: artifact}
+	defer parts.Close()
+	hash := sha256.New()
+	key := artifactKey(artifact.File_name)
+	if err := store.Put(key, io.TeeReader(parts, hash),
+		artifact.Size); err != nil {
+		fmt.Println(err)
+		return err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if sum != strings.ToLower(checksum.Sha256) {
+		store.Delete(key)
+		db.DeleteArtifact(artifact.Id)
+		deleteArtifactParts(artifact)
+		return ChecksumMismatch
+	}
+
+	// the stored file must not outlive a failed update
+	if err := db.CompleteArtifact(artifact.Id, sum); err != nil {
+		fmt.Println(err)
+		store.Delete(key)
+		return err
+	}
+	deleteArtifactParts(artifact)
+	*ack = true
+
+	return nil
+}
+
+// Returns the unfinished artifact specified by its id while holding the lock on
+// the uploads of the artifacts of its task. The lock must be released via
+// `unlockArtifacts`.
+func (api *WorkerAPI) lockUploadingArtifact(worker_token string,
+	aid int64) (*db.Artifact, error) {
+	artifact, err := db.GetUploadingArtifact(worker_token, aid)
+	if err != nil {
+		return nil, err
+	}
+	tid := artifact.Tid
+
+	api.artifact_guard.Lock()
+	lock, ok := api.artifact_locks[tid]
+	if !ok {
+		lock = &artifact_lock{}
+		api.artifact_locks[tid] = lock
+	}
+	lock.users++
+	api.artifact_guard.Unlock()
+	lock.Lock()
+
+	// the artifact may have changed while waiting for the lock
+	if artifact, err = db.GetUploadingArtifact(worker_token, aid); err != nil {
+		api.unlockArtifacts(tid)
+		return nil, err
+	}
+
+	return artifact, nil
+}
+
+// Release the lock on the uploads of the artifacts of the task acquired via
+// `lockUploadingArtifact`.
+func (api *WorkerAPI) unlockArtifacts(tid int64) {
+	api.artifact_guard.Lock()
+	defer api.artifact_guard.Unlock()
+
+	lock := api.artifact_locks[tid]
+	lock.Unlock()
+	lock.users--
+	if lock.users == 0 {
+		delete(api.artifact_locks, tid)
+	}
+}
+
 // Wait for task to complete its execution or until it is canceled. Must be
 // called immediately after `GetTask`.
 func (api *WorkerAPI) WaitForTaskCancelation(task Task, canceled *bool) error {
+	var err error
+	*canceled, err = api.waitForTaskCancelation(task.Id, task.Worker_token,
+		nil)
+
+	return err
+}
+
+// Same as `WaitForTaskCancelation` but stops waiting as soon as `abort` is
+// closed. In this case `NotFinished` is returned.
+func (api *WorkerAPI) waitForTaskCancelation(tid int64, worker_token string,
+	abort <-chan struct{}) (bool, error) {
+	if err := api.authorizeTask(tid, worker_token); err != nil {
+		return false, err
+	}
+
 	api.guard.RLock()
-	cancel, ok := api.running_workers[task.Id]
+	cancel, ok := api.running_workers[tid]
+	api.guard.RUnlock()
 	if !ok {
-		*canceled = false
-		return NotValidTask
+		return false, NotValidTask
 	}
-	api.guard.RUnlock()
 
-	*canceled = <-cancel
-	delete(api.running_workers, task.Id)
-
-	return nil
+	select {
+	case canceled := <-cancel:
+		api.guard.Lock()
+		delete(api.running_workers, tid)
+		api.guard.Unlock()
+		return canceled, nil
+	case <-abort:
+		return false, NotFinished
+	}
 }
 
-// Mark a pending task as running.
+// Mark a pending task as running. The host name is recorded if the worker
+// reports it.
 func (api *WorkerAPI) PublishTaskStarted(task Task, ack *bool) error {
+	if err := api.authorizeTask(task.Id, task.Worker_token); err != nil {
+		*ack = false
+		return err
+	}
+
 	api.guard.RLock()
 	_, ok := api.running_workers[task.Id]
+	api.guard.RUnlock()
 	if !ok {
 		*ack = true
 		return NotValidTask
 	}
-	api.guard.RUnlock()
 
 	db.UpdateTaskStatus(task.Id, db.Running)
+	if task.Hostname != "" {
+		db.SetTaskWorkerHostname(task.Id, task.Hostname)
+	}
 	*ack = true
 
 	return nil
@@ -276,31 +972,59 @@ func (api *WorkerAPI) PublishTaskStarted(task Task, ack *bool) error {
 
 // Return the task's result back to the server.
 func (api *WorkerAPI) PublishTaskResult(result Result, ack *bool) error {
-	api.guard.RLock()
+	if err := api.authorizeTask(result.Tid, result.Worker_token); err != nil {
+		*ack = false
+		return err
+	}
+
+	// only one result is accepted, a concurrent submission (e.g. a retried
+	// HTTP request) finds the task gone
+	api.guard.Lock()
 	cancel, ok := api.running_workers[result.Tid]
+	delete(api.running_workers, result.Tid)
+	api.guard.Unlock()
 	if !ok {
 		*ack = true
 		return NotValidTask
 	}
-	api.guard.RUnlock()
+	// the signal is dropped if the worker was already signaled
+	select {
+	case cancel <- false:
+	default:
+	}
 
-	output := fmt.Sprintf("Stdout:\n%s\nStderr:\n%s", result.Stdout,
+	task_result := db.TaskResult{
+		Metadata:      limitMetadata(result.Metadata),
+		Exit_status:   result.Exit_status,
+		Infra_failure: result.Infrastructure_failure,
+	}
+	task_result.Stdout, task_result.Stdout_truncated = truncateOutput(
+		result.Stdout)
+	task_result.Stderr, task_result.Stderr_truncated = truncateOutput(
 		result.Stderr)
-	file_name := db.UpdateTaskResult(result.Tid, output, result.Exit_status,
+	file_name, err := db.UpdateTaskResult(result.Tid, &task_result,
 		result.Patch != "")
-	cancel <- false
+	if err != nil {
+		// the task was canceled or timed out in the meantime
+		*ack = true
+		return NotValidTask
+	}
+	db.ReleaseTaskLease(result.Tid)
+	// a draining worker that is to be deleted is done with its last task
+	db.DeleteDrainedWorker(result.Worker_token)
 	*ack = true
+	api.notifyOutputSubscribers(result.Tid)
 
-	if result.Patch != "" {
-		file_path := fmt.Sprintf("%s/%s", GetPatchPath(), file_name)
-		file, err := os.Create(file_path)
-		if err != nil {
-			fmt.Println(err)
-			return err
+	if result.Infrastructure_failure {
+		if task, err := db.GetTaskById(result.Tid); err == nil {
+			retryTask(task)
 		}
-		defer file.Close()
+	}
+	dispatchQueuedTask(result.Tid)
 
-		_, err = file.WriteString(result.Patch)
+	if result.Patch != "" {
+		err := store.Put(fmt.Sprintf("%s/%s", patches_directory, file_name),
+			strings.NewReader(result.Patch), int64(len(result.Patch)))
 		if err != nil {
 			fmt.Println(err)
 			return err
@@ -309,3 +1033,56 @@ func (api *WorkerAPI) PublishTaskResult(result Result, ack *bool) error {
 
 	return nil
 }
+
+// Helper to shorten an output exceeding `max_output_size`. The beginning and
+// the end of the output are kept and separated by a marker stating the number
+// of omitted bytes. Returns the (shortened) output and whether it was
+// shortened.
+func truncateOutput(output string) (string, bool) {
+	if len(output) <= max_output_size {
+		return output, false
+	}
+
+	// cut at the start of a character in order not to split multi-byte UTF-8
+	// characters
+	head := max_output_size / 2
+	for head > 0 && !utf8.RuneStart(output[head]) {
+		head--
+	}
+	tail := len(output) - max_output_size/2
+	for tail < len(output) && !utf8.RuneStart(output[tail]) {
+		tail++
+	}
+
+	return fmt.Sprintf("%s\n[... %d bytes truncated ...]\n%s", output[:head],
+		tail-head, output[tail:]), true
+}
+
+// Helper to restrict the metadata reported by a worker to
+// `max_metadata_entries` entries whose values are at most
+// `max_metadata_value_size` bytes long.
+func limitMetadata(metadata map[string]string) map[string]string {
+	keys := make([]string, 0, len(metadata))
+	for key := range metadata {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if len(keys) > max_metadata_entries {
+		keys = keys[:max_metadata_entries]
+	}
+
+	limited := make(map[string]string)
+	for _, key := range keys {
+		value := metadata[key]
+		if len(value) > max_metadata_value_size {
+			end := max_metadata_value_size
+			for end > 0 && !utf8.RuneStart(value[end]) {
+				end--
+			}
+			value = value[:end] + " [truncated]"
+		}
+		limited[key] = value
+	}
+
+	return limited
+}
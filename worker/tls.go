@@ -0,0 +1,156 @@
+// Transport security of the worker port.
+package worker
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/rpc"
+	"sync"
+)
+
+// Paths of the PEM encoded files configuring TLS on the worker port. If
+// `Client_CA` is set worker clients must present a certificate issued by one
+// of the contained certificate authorities.
+type TLSFiles struct {
+	Certificate string
+	Key         string
+	Client_CA   string
+}
+
+// Custom error messages.
+var (
+	InvalidClientCA = errors.New("No certificate found in the client CA file!")
+)
+
+// Files the current TLS configuration was loaded from (nil if TLS is not
+// enabled).
+var loaded_tls_files *TLSFiles
+
+// Current TLS configuration of the worker port. Replaced by `ReloadTLS`.
+var tls_config *tls.Config
+
+var tls_guard sync.RWMutex
+
+// Helper to read the certificate, key and client CAs from the files.
+func loadTLSConfig(files *TLSFiles) (*tls.Config, error) {
+	certificate, err := tls.LoadX509KeyPair(files.Certificate, files.Key)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	if files.Client_CA != "" {
+		pem, err := ioutil.ReadFile(files.Client_CA)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, InvalidClientCA
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// Reload the certificate, key and client CAs from the files given to `Init`.
+// New connections use the reloaded configuration, established connections are
+// not affected. Thus certificates can be rotated without a restart. If loading
+// fails the previous configuration is kept.
+func ReloadTLS() error {
+	if loaded_tls_files == nil {
+		return nil
+	}
+
+	config, err := loadTLSConfig(loaded_tls_files)
+	if err != nil {
+		return err
+	}
+
+	tls_guard.Lock()
+	tls_config = config
+	tls_guard.Unlock()
+
+	return nil
+}
+
+// Helper to wrap the listener such that every connection is secured by the
+// current TLS configuration.
+func newTLSListener(listener net.Listener, files *TLSFiles) (net.Listener,
+	error) {
+	config, err := loadTLSConfig(files)
+	if err != nil {
+		return nil, err
+	}
+	loaded_tls_files = files
+	tls_config = config
+
+	return tls.NewListener(listener, &tls.Config{
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			tls_guard.RLock()
+			defer tls_guard.RUnlock()
+			return tls_config, nil
+		},
+	}), nil
+}
+
+// Helper to accept connections of worker clients. Every connection is served
+// by its own RPC server in order to know the client certificate presented on
+// it (see `WorkerAPI.forConnection`). Connections carrying HTTP requests are
+// passed on to the HTTP worker API (see `serveHTTPWorkers`).
+func acceptWorkers(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Print("rpc.Serve: accept:", err.Error())
+			return
+		}
+		go serveWorker(conn)
+	}
+}
+
+// Helper to serve the RPCs of a single worker client.
+func serveWorker(conn net.Conn) {
+	var fingerprint string
+	if tls_conn, ok := conn.(*tls.Conn); ok {
+		if err := tls_conn.Handshake(); err != nil {
+			fmt.Println(err)
+			conn.Close()
+			return
+		}
+		certificates := tls_conn.ConnectionState().PeerCertificates
+		if len(certificates) > 0 {
+			fingerprint = certificateFingerprint(certificates[0])
+		}
+	}
+
+	// HTTP workers use the worker port as well
+	worker_conn := &workerConn{conn, bufio.NewReader(conn), fingerprint}
+	if worker_conn.isHTTP() {
+		http_connections <- worker_conn
+		return
+	}
+
+	server := rpc.NewServer()
+	server.Register(api.forConnection(fingerprint))
+	server.ServeConn(worker_conn)
+}
+
+// Helper to compute the hex encoded SHA-256 fingerprint of the certificate.
+func certificateFingerprint(certificate *x509.Certificate) string {
+	sum := sha256.Sum256(certificate.Raw)
+	return hex.EncodeToString(sum[:])
+}
@@ -5,37 +5,104 @@ import (
 	"errors"
 	"fmt"
 	"github.com/AnalysisBotsPlatform/platform/db"
+	"github.com/AnalysisBotsPlatform/platform/storage"
 	"github.com/gorhill/cronexpr"
+	"io"
 	"log"
 	"net"
-	"net/rpc"
 	"os"
 	"os/exec"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
-// Maximal duration in seconds for each task.
-const max_task_time int64 = 60
+// Duration in seconds of a worker's lease on a task. The worker has to renew
+// the lease (see `WorkerAPI.Heartbeat`) before it expires.
+const lease_duration int64 = 30
+
+// Delay in seconds before the first retry of a task that failed because of an
+// infrastructure problem. The delay doubles with every further attempt.
+const retry_base_delay int64 = 30
+
+// Maximal delay in seconds before a retry.
+const retry_max_delay int64 = 3600
+
+// Maximal size in bytes of the stored stdout and stderr of a task. Larger
+// outputs are truncated (see `truncateOutput`).
+const max_output_size = 1 << 20
+
+// Duration in seconds for which the streamed output of a finished task is kept
+// such that clients following the output can fetch its last chunks. Afterwards
+// the output is only available as the stored stdout and stderr of the task.
+const output_retention int64 = 600
+
+// Maximal number of metadata entries stored per task and maximal size in bytes
+// of each of their values.
+const (
+	max_metadata_entries    = 32
+	max_metadata_value_size = 1024
+)
 
 // Cache subdirectory where projects are cloned to.
 const projects_directory = "projects"
 
-// Cache subdirectory where Git patches are located.
+// Storage directory where Git patches are located.
 const patches_directory = "patches"
 
+// Storage directory where artifacts are located.
+const artifacts_directory = "artifacts"
+
+// Storage directory where the parts of artifacts are kept while they are
+// uploaded.
+const uploads_directory = "uploads"
+
+// Maximal size in bytes of a single artifact.
+const max_artifact_size int64 = 100 << 20
+
+// Maximal length in bytes of an artifact's name and content type (see the
+// `artifacts` table).
+const (
+	max_artifact_name_length = 255
+	max_content_type_length  = 100
+)
+
+// Maximal length in bytes of a worker's name and of each of its labels (see
+// the `workers` table).
+const (
+	max_worker_name_length  = 50
+	max_worker_label_length = 50
+)
+
+// Number of upcoming executions shown as preview of a schedule.
+const Schedule_preview_size = 5
+
+// Number of execution times of a schedule computed at once when determining
+// the executions missed while the platform was down.
+const missed_times_batch_size = 100
+
 // Absolute path to patch files directory.
 var projects_path string
 
-// Absolute path to patch files directory.
-var patches_path string
+// Storage of Git patches and artifacts.
+var store storage.Store
+
+// URL under which the projects of tasks can be cloned (see `Task.Clone_url`).
+var clone_base_url string
+
+// Bounds in seconds of the timeouts of tasks. Timeouts of bots and task groups
+// outside of these bounds are adjusted (see `taskTimeout`).
+var min_task_time, max_task_time int64
 
 // WorkerAPI instance used to interact with the workers.
 var api *WorkerAPI
 
 // Custom error messages.
 var (
-	PatchFailure = errors.New("Patch cannot be applied!")
+	PatchFailure         = errors.New("Patch cannot be applied!")
+	InvalidTimezone      = errors.New("Unknown time zone!")
+	OverlappingExecution = errors.New(
+		"A previous execution of the action is still active!")
 )
 
 // ticker to coordinate periodic tasks
@@ -46,25 +113,57 @@ var pauseChan chan bool
 
 var runningTasks map[int64]chan bool
 
+// guard of `runningTasks`
+var runningTasksGuard sync.Mutex
+
+// Lock serializing the creation of the tasks of a task group (see
+// `createTask`). `users` is the number of calls holding or waiting for the
+// lock.
+type group_lock struct {
+	sync.Mutex
+	users int
+}
+
+// Locks of the task groups whose tasks are being created and the guard of the
+// map
+var group_locks = make(map[int64]*group_lock)
+var group_guard sync.Mutex
+
 // Initialization of the worker. Sets up the RPC infrastructure.
 // Furthermore here the runners for ScheduledTask and OneTimeTask are being
 // spawned in case there exists some entries in the database for those that
 // should be execued.
-func Init(port, cache_path string) error {
+// Git patches and artifacts are kept in `file_store`.
+// If `tls_files` is given the worker port only accepts TLS connections.
+// Workers clone the projects of their tasks from the controller's clone proxy
+// located at `clone_url`.
+// The timeout of every task is kept between `min_timeout` and `max_timeout`
+// seconds.
+func Init(port, cache_path string, file_store storage.Store,
+	tls_files *TLSFiles, clone_url string, min_timeout,
+	max_timeout int64) error {
+	store = file_store
+	clone_base_url = strings.TrimSuffix(clone_url, "/")
+	min_task_time, max_task_time = min_timeout, max_timeout
 	api = NewWorkerAPI()
-	rpc.Register(api)
 
 	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", port))
 	if err != nil {
 		return err
 	}
+	if tls_files != nil {
+		if listener, err = newTLSListener(listener, tls_files); err != nil {
+			return err
+		}
+	}
 
 	pauseChan = make(chan bool)
 	runningTasks = make(map[int64]chan bool)
 
 	recoverActiveTasks()
 
-	go rpc.Accept(listener)
+	go acceptWorkers(listener)
+	go serveHTTPWorkers(listener)
 
 	projects_path = fmt.Sprintf("%s/%s", cache_path, projects_directory)
 	if _, err := os.Stat(projects_path); os.IsNotExist(err) {
@@ -76,39 +175,202 @@ func Init(port, cache_path string) error {
 		}
 	}
 
-	patches_path = fmt.Sprintf("%s/%s", cache_path, patches_directory)
-	if _, err := os.Stat(patches_path); os.IsNotExist(err) {
-		fmt.Println("Patch cache directory does not exist!")
-		fmt.Printf("Create patch cache directory %s\n", patches_path)
-		if err := os.MkdirAll(patches_path, 0755); err != nil {
-			fmt.Println("Patch cache directory cannot be created!")
-			return err
+	return nil
+}
+
+// Opens the given patch file. The caller must close the returned reader.
+func OpenPatch(file_name string) (io.ReadCloser, error) {
+	return store.Get(fmt.Sprintf("%s/%s", patches_directory, file_name))
+}
+
+// Opens the content of the given artifact. The caller must close the returned
+// reader.
+func OpenArtifact(artifact *db.Artifact) (io.ReadCloser, error) {
+	return store.Get(artifactKey(artifact.File_name))
+}
+
+// Returns the storage key of the artifact's content.
+func artifactKey(file_name string) string {
+	return fmt.Sprintf("%s/%s", artifacts_directory, file_name)
+}
+
+// Returns the storage key of the n-th part of an artifact that is uploaded.
+func artifactPartKey(file_name string, n int64) string {
+	return fmt.Sprintf("%s/%s/%d", uploads_directory, file_name, n)
+}
+
+// Deletes the uploaded parts of the artifact.
+func deleteArtifactParts(artifact *db.Artifact) {
+	for n := int64(0); n < artifact.Parts; n++ {
+		if err := store.Delete(artifactPartKey(artifact.File_name,
+			n)); err != nil {
+			fmt.Println(err)
 		}
 	}
+}
 
-	return nil
+// Reader of the content of an artifact that is uploaded, i.e. of its parts one
+// after another. Each part is opened when the previous one was read.
+type partsReader struct {
+	artifact *db.Artifact
+	next     int64
+	current  io.ReadCloser
 }
 
-// Returns the path to the patch directory
-func GetPatchPath() string {
-	return patches_path
+// Reads from the current part and proceeds to the next part at its end.
+func (r *partsReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if r.next == r.artifact.Parts {
+				return 0, io.EOF
+			}
+			part, err := store.Get(artifactPartKey(r.artifact.File_name,
+				r.next))
+			if err != nil {
+				return 0, err
+			}
+			r.current = part
+			r.next++
+		}
+
+		n, err := r.current.Read(p)
+		if err == io.EOF {
+			r.current.Close()
+			r.current = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Closes the current part.
+func (r *partsReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+
+	return err
 }
 
 // Creates a new task. This includes the following steps:
+// - Applying the overlap policy of the task group (see `createTask`).
 // - Creating a database entry.
 // - Creating a new communication channel.
 // - Starting an asynchronous task.
 // The task id of the newly created task is returned.
 func CreateNewTask(parentTaskId int64) (int64, error) {
+	return createTask(parentTaskId, nil)
+}
+
+// Helper to create a new task of the task group unless the group's overlap
+// policy forbids it while previous executions are active. Depending on the
+// policy the previous executions are canceled or the new task waits until the
+// running execution finished (see `dispatchQueuedTask`). If `catch_up` is
+// given the task is marked as catching up the execution due at this time.
+// Returns `OverlappingExecution` if no task was created. The tasks of a task
+// group are created one at a time, thus the active executions checked against
+// the overlap policy cannot change in the meantime.
+func createTask(gtid int64, catch_up *time.Time) (int64, error) {
+	lockTaskGroup(gtid)
+	defer unlockTaskGroup(gtid)
+
+	overlap, err := db.GetOverlapPolicy(gtid)
+	if err != nil {
+		return -1, err
+	}
+	activeChildren, err := db.GetActiveChildren(gtid)
+	if err != nil {
+		return -1, err
+	}
+
+	queued := false
+	if len(activeChildren) > 0 {
+		switch overlap {
+		case db.Overlap_skip:
+			return -1, OverlappingExecution
+		case db.Overlap_cancel_previous:
+			for _, childTask := range activeChildren {
+				Cancel(childTask.Id, db.Canceled_by_newer, db.Platform_actor)
+			}
+		case db.Overlap_queue_one:
+			for _, childTask := range activeChildren {
+				if childTask.IsPending() {
+					return -1, OverlappingExecution
+				}
+			}
+			queued = true
+		}
+	}
 
-	newTask, tErr := db.CreateNewChildTask(parentTaskId)
+	newTask, tErr := db.CreateNewChildTask(gtid, nil, 0)
 	if tErr != nil {
 		return -1, tErr
 	}
-	api.assignTask(newTask)
+	if catch_up != nil {
+		if err := db.SetTaskCatchUp(newTask.Id, *catch_up); err != nil {
+			return -1, err
+		}
+		newTask.Catch_up_time = catch_up
+	}
+	if queued {
+		// the running execution may have finished in the meantime
+		api.assignPendingTask(newTask)
+	} else {
+		api.assignTask(newTask)
+	}
 	return newTask.Id, nil
 }
 
+// Acquire the lock on the creation of the tasks of the task group. The lock
+// must be released via `unlockTaskGroup`.
+func lockTaskGroup(gtid int64) {
+	group_guard.Lock()
+	lock, ok := group_locks[gtid]
+	if !ok {
+		lock = &group_lock{}
+		group_locks[gtid] = lock
+	}
+	lock.users++
+	group_guard.Unlock()
+	lock.Lock()
+}
+
+// Release the lock on the creation of the tasks of the task group acquired via
+// `lockTaskGroup`.
+func unlockTaskGroup(gtid int64) {
+	group_guard.Lock()
+	defer group_guard.Unlock()
+
+	lock := group_locks[gtid]
+	lock.Unlock()
+	lock.users--
+	if lock.users == 0 {
+		delete(group_locks, gtid)
+	}
+}
+
+// Assigns the execution of the task's group that waited for the task to finish
+// (see `db.Overlap_queue_one`). Must be called whenever a task finished.
+func dispatchQueuedTask(tid int64) {
+	task, err := db.GetTaskById(tid)
+	if err != nil {
+		return
+	}
+	queued, err := db.GetQueuedTask(task.Gid)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	if queued != nil {
+		api.assignPendingTask(queued)
+	}
+}
+
 // Creates a new go routine, which handles the execution of the scheduled task
 // according to the specified dates.
 // First it creates a new channel in order to cancel the created go routine and
@@ -117,7 +379,9 @@ func CreateNewTask(parentTaskId int64) (int64, error) {
 // In the end it runs the go routine (asynchronous call).
 func RunScheduledTask(stid int64) {
 	cancelChan := make(chan bool, 1)
+	runningTasksGuard.Lock()
 	runningTasks[stid] = cancelChan
+	runningTasksGuard.Unlock()
 	go runScheduledTask(stid, cancelChan)
 }
 
@@ -128,11 +392,78 @@ func RunScheduledTask(stid int64) {
 // particular channel later on.
 // In the end it runs the go routine (asynchronous call).
 func RunOneTimeTask(otid int64) {
-	cancelChan := make(chan bool)
+	cancelChan := make(chan bool, 1)
+	runningTasksGuard.Lock()
 	runningTasks[otid] = cancelChan
+	runningTasksGuard.Unlock()
 	go runOneTimeTask(otid, cancelChan)
 }
 
+// Stops the scheduling go routine of the paused scheduled task. In contrast to
+// `CancelScheduledTask` its status and its running "child" tasks are kept. The
+// task is resumed by starting a new go routine via `RunScheduledTask`, which
+// computes a fresh next execution time.
+func PauseScheduledTask(stid int64) {
+	stopScheduler(stid)
+}
+
+// Stops the scheduling go routine of the paused one time task. The task is
+// resumed via `RunOneTimeTask`. If its date passed in the meantime it is
+// executed right away.
+func PauseOneTimeTask(otid int64) {
+	stopScheduler(otid)
+}
+
+// Restarts the scheduling go routine of the active scheduled task after it was
+// edited, i.e. its next execution time is computed afresh.
+func RestartScheduledTask(stid int64) {
+	stopScheduler(stid)
+	RunScheduledTask(stid)
+}
+
+// Restarts the scheduling go routine of the active one time task after it was
+// edited. If its new date passed already it is executed right away.
+func RestartOneTimeTask(otid int64) {
+	stopScheduler(otid)
+	RunOneTimeTask(otid)
+}
+
+// Helper to stop the scheduling go routine of the task group by sending on its
+// cancel channel. Groups that were paused have no scheduling go routine. The go
+// routine of a one time task may have terminated already, the buffered cancel
+// channel never blocks then. Once this function returns the go routine does not
+// write anymore (see `whileScheduling`).
+func stopScheduler(gtid int64) {
+	runningTasksGuard.Lock()
+	defer runningTasksGuard.Unlock()
+
+	cancelChan, ok := runningTasks[gtid]
+	if !ok {
+		return
+	}
+	select {
+	case cancelChan <- true:
+	default:
+	}
+	delete(runningTasks, gtid)
+}
+
+// Helper to run `action` on behalf of the scheduling go routine of the task
+// group that listens on `cancelChan` unless the go routine was stopped in the
+// meantime (e.g. because the group was edited and a new go routine was
+// started). Returns false if it was stopped, the go routine must terminate
+// then.
+func whileScheduling(gtid int64, cancelChan chan bool, action func()) bool {
+	runningTasksGuard.Lock()
+	defer runningTasksGuard.Unlock()
+
+	if runningTasks[gtid] != cancelChan {
+		return false
+	}
+	action()
+	return true
+}
+
 // Cancels the scheduling go routine for this particular task and its "child"
 // tasks that are being executed at the moment by some worker.
 // It first sends a value on the corresponding cancel channel which causes the
@@ -141,14 +472,9 @@ func RunOneTimeTask(otid int64) {
 // Then it retrieves all the "child" tasks (the actual executions) of this task
 // that are still running (being executed by some worker), iterates over them
 // and by that cancels the execution of all of them.
-func CancelScheduledTask(stid int64) error {
-	cancelChan, ok := runningTasks[stid]
-	if ok {
-		cancelChan <- true
-	} else {
-		return errors.New(
-			"The provided id does not correspond to a running task.")
-	}
+// `actor` is recorded as the one who canceled them.
+func CancelScheduledTask(stid int64, actor string) error {
+	stopScheduler(stid)
 	err := db.UpdateScheduledTaskStatus(stid, db.Complete)
 	runningChildren, gErr := db.GetActiveChildren(stid)
 	if gErr != nil {
@@ -156,7 +482,7 @@ func CancelScheduledTask(stid int64) error {
 	}
 
 	for _, childTask := range runningChildren {
-		Cancel(childTask.Id)
+		Cancel(childTask.Id, db.Canceled_with_group, actor)
 	}
 
 	return err
@@ -170,14 +496,9 @@ func CancelScheduledTask(stid int64) error {
 // Then it retrieves all the "child" tasks (the actual executions) of this task
 // that are still running (being executed by some worker), iterates over them
 // and by that cancels the execution of all of them.
-func CancelOneTimeTask(stid int64) error {
-	cancelChan, ok := runningTasks[stid]
-	if ok {
-		cancelChan <- true
-	} else {
-		return errors.New(
-			"The provided id does not correspond to a running task.")
-	}
+// `actor` is recorded as the one who canceled them.
+func CancelOneTimeTask(stid int64, actor string) error {
+	stopScheduler(stid)
 	err := db.UpdateOneTimeTaskStatus(stid, db.Complete)
 	runningChildren, gErr := db.GetActiveChildren(stid)
 	if gErr != nil {
@@ -185,7 +506,7 @@ func CancelOneTimeTask(stid int64) error {
 	}
 
 	for _, childTask := range runningChildren {
-		Cancel(childTask.Id)
+		Cancel(childTask.Id, db.Canceled_with_group, actor)
 	}
 
 	return err
@@ -196,7 +517,8 @@ func CancelOneTimeTask(stid int64) error {
 // database to complete.
 // Then it retrieves all the currently executed tasks from the databse, iterates
 // over them and cancels them.
-func CancelEventTask(stid int64) error {
+// `actor` is recorded as the one who canceled them.
+func CancelEventTask(stid int64, actor string) error {
 	err := db.UpdateEventTaskStatus(stid, db.Complete)
 	runningChildren, gErr := db.GetActiveChildren(stid)
 	if gErr != nil {
@@ -204,7 +526,7 @@ func CancelEventTask(stid int64) error {
 	}
 
 	for _, childTask := range runningChildren {
-		Cancel(childTask.Id)
+		Cancel(childTask.Id, db.Canceled_with_group, actor)
 	}
 
 	return err
@@ -213,14 +535,15 @@ func CancelEventTask(stid int64) error {
 // Cancels the actually execution of that instant task.
 // Therefore it retrieves the "child" task (there should not be more than one)
 // for that task and cancels it.
-func CancelInstantTask(stid int64) error {
+// `actor` is recorded as the one who canceled it.
+func CancelInstantTask(stid int64, actor string) error {
 	runningChildren, gErr := db.GetActiveChildren(stid)
 	if gErr != nil {
 		return gErr
 	}
 
 	for _, childTask := range runningChildren {
-		Cancel(childTask.Id)
+		Cancel(childTask.Id, db.Canceled_with_group, actor)
 	}
 
 	return nil
@@ -230,21 +553,124 @@ func CancelInstantTask(stid int64) error {
 // execution of GetTask.
 func DeleteWorker(worker_token string) {
 	var ack bool
-	api.UnregisterWorker(worker_token, &ack)
+	api.unregisterWorker(worker_token, &ack)
+}
+
+// Stop assigning tasks to the worker (see `db.SetWorkerDraining`). This
+// continues a potentially blocked execution of GetTask.
+func DrainWorker(worker_token string) {
+	api.drainWorker(worker_token)
 }
 
 // Cancels the running task specified by the given task id using the channel.
-// Also updates the database entry accordingly.
-func Cancel(tid int64) {
-	api.cancelTask(tid)
+// Also updates the database entry accordingly. `reason` describes why the task
+// is canceled and `actor` who cancels it (see `db.CancelTask`).
+func Cancel(tid int64, reason, actor string) {
+	api.cancelTask(tid, reason, actor)
+	dispatchQueuedTask(tid)
+}
+
+// Follow the output of the task. The returned channel receives a value
+// whenever new output of the task was stored (see `db.GetTaskOutput`) or the
+// task finished. The subscription must be released via
+// `UnsubscribeTaskOutput`.
+func SubscribeTaskOutput(tid int64) chan bool {
+	return api.subscribeOutput(tid)
 }
 
-// This function cancles all tasks which succeeded the 'max_task_time'
+// Stop following the output of the task.
+func UnsubscribeTaskOutput(tid int64, subscription chan bool) {
+	api.unsubscribeOutput(tid, subscription)
+}
+
+// This function stops all tasks which exceeded their deadline and marks them
+// as timed out. Timed out tasks are retried like tasks that failed because of
+// an infrastructure problem.
 func CancelTimedOverTasks() {
-	tasks, _ := db.GetTimedOverTasks(max_task_time)
+	tasks, _ := db.GetTimedOverTasks()
 	for _, e := range tasks {
-		Cancel(e)
+		api.timeOutTask(e, "The execution exceeded its deadline.")
+	}
+}
+
+// Returns the maximal duration in seconds of an execution of the task group,
+// i.e. the timeout of the task group or its bot kept within `min_task_time`
+// and `max_task_time`.
+func taskTimeout(gid int64) int64 {
+	timeout, err := db.GetTaskTimeout(gid)
+	if err != nil {
+		fmt.Println(err)
+		timeout = max_task_time
+	}
+	if timeout < min_task_time {
+		timeout = min_task_time
+	}
+	if timeout > max_task_time {
+		timeout = max_task_time
+	}
+
+	return timeout
+}
+
+// This function deletes the streamed output of all tasks which finished more
+// than `output_retention` seconds ago.
+func PruneTaskOutput() {
+	db.DeleteFinishedTaskOutput(output_retention)
+}
+
+// This function reclaims all tasks whose worker did not renew its lease in
+// time, i.e. the worker crashed or lost its connection. The tasks fail because
+// of an infrastructure problem and are retried up to their retry limit.
+func ReapExpiredLeases() {
+	leases, err := db.GetExpiredLeases()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, lease := range leases {
+		api.reclaimTask(lease)
+	}
+}
+
+// Creates another attempt of the given task if it failed because of an
+// infrastructure problem (including a worker that stopped responding) or timed
+// out and its retry limit is not exhausted
+// yet. The n-th retry is delayed by `retry_base_delay` * 2^(n-1) seconds but at
+// most by `retry_max_delay` seconds.
+func retryTask(task *db.Task) {
+	if !task.Infra_failure && !task.IsTimedOut() {
+		return
+	}
+	limit, err := db.GetRetryLimit(task.Gid)
+	if err != nil || task.Attempt > limit {
+		return
+	}
+
+	delay := retry_base_delay
+	for i := int64(1); i < task.Attempt && delay < retry_max_delay; i++ {
+		delay *= 2
+	}
+	if delay > retry_max_delay {
+		delay = retry_max_delay
+	}
+
+	retry, err := db.CreateNewChildTask(task.Gid, task, delay)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	scheduleDelayedTask(retry)
+}
+
+// Assigns the task to a worker as soon as its delay elapsed.
+func scheduleDelayedTask(task *db.Task) {
+	var delay time.Duration
+	if task.Not_before != nil {
+		delay = task.Not_before.Sub(time.Now())
 	}
+	time.AfterFunc(delay, func() {
+		api.assignPendingTask(task)
+	})
 }
 
 // Cancels all schedulers that are responsible for scheduling the registered
@@ -266,27 +692,46 @@ func StopPeriodRunners() {
 // a new task.
 // In parallel to the sleeping it listens to the channel to cancel the task and
 // terminate and to the one to terminate temporarily but do not mark it as
-// canceled in the database.
+// canceled in the database. The cancel channel receives a value if the task was
+// canceled, paused or restarted. The go routine writes nothing once it was
+// stopped (see `whileScheduling`), the canceler marks the task as complete.
 func runScheduledTask(stid int64, cancelChan chan bool) {
+	complete := func() {
+		db.UpdateScheduledTaskStatus(stid, db.Complete)
+	}
 	for {
 		scheduledTask, err := db.GetScheduledTask(stid)
 		if err != nil {
-			db.UpdateScheduledTaskStatus(stid, db.Complete)
+			whileScheduling(stid, cancelChan, complete)
+			return
+		}
+		nextTimes, err := NextScheduleTimes(scheduledTask.Cron,
+			scheduledTask.Timezone, time.Now(), 1)
+		if err != nil {
+			whileScheduling(stid, cancelChan, complete)
+			return
+		}
+		nextTime := nextTimes[0]
+		sleepTime := nextTime.Sub(time.Now())
+		var uErr error
+		if !whileScheduling(stid, cancelChan, func() {
+			uErr = db.UpdateNextScheduleTime(scheduledTask.Id, nextTime)
+		}) {
 			return
 		}
-		nextTime := cronexpr.MustParse(scheduledTask.Cron).
-			Next(time.Now().UTC())
-		sleepTime := nextTime.Sub(time.Now().UTC())
-		uErr := db.UpdateNextScheduleTime(scheduledTask.Id, nextTime)
 		if uErr != nil {
-			db.UpdateScheduledTaskStatus(stid, db.Complete)
+			whileScheduling(stid, cancelChan, complete)
 			return
 		}
 		select {
 		case <-time.After(sleepTime):
-			CreateNewTask(stid)
+			if !whileScheduling(stid, cancelChan, func() {
+				CreateNewTask(stid)
+			}) {
+				return
+			}
 		case <-cancelChan:
-			db.UpdateScheduledTaskStatus(stid, db.Complete)
+			// the task was canceled (and completed), paused or restarted
 			return
 		case <-pauseChan:
 			return
@@ -294,6 +739,34 @@ func runScheduledTask(stid int64, cancelChan chan bool) {
 	}
 }
 
+// Computes the next `n` execution times after `after` of the cron expression.
+// The expression is evaluated in the IANA time zone `timezone` (e.g.
+// "Europe/Berlin"), thus the executions keep their local time when daylight
+// saving time begins or ends. The times are returned in this time zone.
+func NextScheduleTimes(cron_exp, timezone string, after time.Time,
+	n int) ([]time.Time, error) {
+	expression, err := cronexpr.Parse(cron_exp)
+	if err != nil {
+		return nil, fmt.Errorf("The cron expression <%s> could not have been "+
+			"parsed.", cron_exp)
+	}
+	if timezone == "" || timezone == "Local" {
+		return nil, InvalidTimezone
+	}
+	location, err := time.LoadLocation(timezone)
+	if err != nil {
+		return nil, InvalidTimezone
+	}
+
+	times := expression.NextN(after.In(location), uint(n))
+	if len(times) == 0 {
+		return nil, fmt.Errorf("The cron expression <%s> never matches.",
+			cron_exp)
+	}
+
+	return times, nil
+}
+
 // Takes care of the execution of the task according to the specified date.
 // It computes the time to sleep, sleeps for that amount of time and after the
 // time has expired it executes the task and terminates.
@@ -308,10 +781,12 @@ func runOneTimeTask(otid int64, cancelChan chan bool) {
 	duration := oneTimeTask.Exec_time.Sub(time.Now().UTC())
 	select {
 	case <-time.After(duration):
-		CreateNewTask(otid)
-		db.UpdateOneTimeTaskStatus(otid, db.Complete)
+		whileScheduling(otid, cancelChan, func() {
+			CreateNewTask(otid)
+			db.UpdateOneTimeTaskStatus(otid, db.Complete)
+		})
 	case <-cancelChan:
-		// one time already completed in CancelOneTimeTask
+		// one time already completed in CancelOneTimeTask or paused
 		return
 	case <-pauseChan:
 		return
@@ -319,20 +794,126 @@ func runOneTimeTask(otid int64, cancelChan chan bool) {
 }
 
 // Retrieves the active scheduled and event tasks and starts a new go routine
-// to schedule them.
+// to schedule them. Executions that were missed while the platform was down
+// are caught up first (see `catchUpScheduledTask`), one time tasks whose date
+// passed are executed right away. Delayed retries are scheduled as well.
 func recoverActiveTasks() {
 	sched_ids, err := db.GetScheduledTaskIdsWithStatus(db.Active)
 	if err == nil {
 		for _, id := range sched_ids {
+			catchUpScheduledTask(id)
 			RunScheduledTask(id)
 		}
 	}
 	oneTime_ids, err := db.GetOneTimeTaskIdsWithStatus(db.Active)
 	if err == nil {
 		for _, id := range oneTime_ids {
+			oneTimeTask, err := db.GetOneTimeTask(id)
+			if err == nil && oneTimeTask.Exec_time.Before(time.Now()) {
+				if _, err := createCatchUpTask(id,
+					oneTimeTask.Exec_time); err != nil {
+					log.Println(err)
+				}
+				db.UpdateOneTimeTaskStatus(id, db.Complete)
+				continue
+			}
 			RunOneTimeTask(id)
 		}
 	}
+	delayed, err := db.GetDelayedTasks()
+	if err == nil {
+		for _, task := range delayed {
+			scheduleDelayedTask(task)
+		}
+	}
+}
+
+// Creates the executions of the scheduled task that were missed while the
+// platform was down according to its misfire policy. The missed executions
+// are the one stored as next execution time and all further ones of the cron
+// expression up to now. A missed execution that cannot be created (e.g. due to
+// the overlap policy) is logged and skipped.
+func catchUpScheduledTask(stid int64) {
+	scheduledTask, err := db.GetScheduledTask(stid)
+	if err != nil || scheduledTask.Next.IsZero() {
+		return
+	}
+
+	limit := 0
+	switch scheduledTask.Misfire {
+	case db.Misfire_run_once:
+		limit = 1
+	case db.Misfire_run_all:
+		limit = int(scheduledTask.Misfire_limit)
+		if limit > db.Max_misfire_limit {
+			limit = db.Max_misfire_limit
+		}
+	}
+	missed, total := missedScheduleTimes(scheduledTask, time.Now(), limit)
+	if total == 0 {
+		return
+	}
+	log.Printf("Scheduled task %d missed %d executions, catching up %d",
+		stid, total, len(missed))
+
+	for _, due := range missed {
+		_, err := createCatchUpTask(stid, due)
+		if err == OverlappingExecution {
+			log.Printf("Scheduled task %d skipped the execution due at %s "+
+				"as a previous one is active", stid, due)
+		} else if err != nil {
+			log.Printf("Scheduled task %d failed to catch up the execution "+
+				"due at %s: %v", stid, due, err)
+		}
+	}
+}
+
+// Helper to determine the executions of the scheduled task that were due
+// between its stored next execution time and `now`. Returns the latest `limit`
+// of them along with their total number.
+func missedScheduleTimes(scheduledTask *db.ScheduledTask, now time.Time,
+	limit int) ([]time.Time, int) {
+	if !scheduledTask.Next.Before(now) {
+		return nil, 0
+	}
+
+	var missed []time.Time
+	total := 0
+	count := func(due time.Time) {
+		total++
+		if limit > 0 {
+			missed = append(missed, due)
+			if len(missed) > limit {
+				missed = missed[1:]
+			}
+		}
+	}
+
+	// the times are computed in batches as there may be plenty of them
+	due := scheduledTask.Next
+	count(due)
+	for {
+		next_times, err := NextScheduleTimes(scheduledTask.Cron,
+			scheduledTask.Timezone, due, missed_times_batch_size)
+		if err != nil {
+			break
+		}
+		for _, next := range next_times {
+			if !next.Before(now) {
+				return missed, total
+			}
+			count(next)
+		}
+		due = next_times[len(next_times)-1]
+	}
+
+	return missed, total
+}
+
+// Creates a new task of the task group which catches up the execution that was
+// due at `due`. The task is marked as such and assigned like any other task.
+func createCatchUpTask(gtid int64, due time.Time) (int64, error) {
+	return createTask(gtid, &due)
 }
 
 // Apply the patch to the project on the given branch.
@@ -357,12 +938,14 @@ func CommitPatch(task *db.Task, branch_name string) error {
 	defer os.RemoveAll(clone_path)
 
 	// apply patch
-	patch_file, err := filepath.Abs(fmt.Sprintf("%s/%s", patches_path,
-		task.Patch))
+	patch, err := OpenPatch(task.Patch)
 	if err != nil {
+		log.Println(err)
 		return PatchFailure
 	}
-	patch_cmd := exec.Command("git", "am", patch_file)
+	defer patch.Close()
+	patch_cmd := exec.Command("git", "am")
+	patch_cmd.Stdin = patch
 	patch_cmd.Dir = clone_path
 	if out, err := patch_cmd.CombinedOutput(); err != nil {
 		log.Println(string(out))
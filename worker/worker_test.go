@@ -0,0 +1,261 @@
+package worker
+
+import (
+	"database/sql"
+	"github.com/AnalysisBotsPlatform/platform/db"
+	"github.com/AnalysisBotsPlatform/platform/internal/testdb"
+	"sync"
+	"testing"
+)
+
+// Connection to the test database used to prepare the tests' data.
+var test_db *sql.DB
+
+// Connects to a fresh schema of the test database (see `testdb.SetUp`), which
+// the database package uses as well. Skips the test if no test database is
+// configured.
+func setUpTestDB(t *testing.T) {
+	var config *testdb.Config
+	test_db, config = testdb.SetUp(t)
+	if err := db.OpenDB(config.Host, config.User, config.Password,
+		config.Name); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.CloseDB)
+
+	api = NewWorkerAPI()
+}
+
+// Helper to create a task of a bot with the given retry limit which is
+// running on a worker. Returns the task's id.
+func createRunningTask(t *testing.T, max_retries int64) int64 {
+	var uid, pid, bid, gid, wid int64
+
+	if err := test_db.QueryRow("INSERT INTO users (gh_id, username, token, " +
+		"worker_token, admin) VALUES (1, 'alice', 'token-alice', " +
+		"'worker-alice', false) RETURNING id").Scan(&uid); err != nil {
+		t.Fatal(err)
+	}
+	if err := test_db.QueryRow("INSERT INTO projects (gh_id, name) " +
+		"VALUES (1, 'owner/project') RETURNING id").Scan(&pid); err != nil {
+		t.Fatal(err)
+	}
+	if err := test_db.QueryRow("INSERT INTO bots (name, max_retries) "+
+		"VALUES ('owner/bot', $1) RETURNING id", max_retries).
+		Scan(&bid); err != nil {
+		t.Fatal(err)
+	}
+	if err := test_db.QueryRow("INSERT INTO group_tasks (uid, pid, bid) "+
+		"VALUES ($1, $2, $3) RETURNING id", uid, pid, bid).
+		Scan(&gid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := test_db.Exec("INSERT INTO instant_tasks (id) VALUES ($1)",
+		gid); err != nil {
+		t.Fatal(err)
+	}
+	if err := test_db.QueryRow("INSERT INTO workers (uid, token, name, "+
+		"last_contact, active, shared) VALUES ($1, 'worker', 'worker', "+
+		"now(), true, false) RETURNING id", uid).Scan(&wid); err != nil {
+		t.Fatal(err)
+	}
+
+	task, err := db.CreateNewChildTask(gid, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AcquireTaskLease(task.Id, wid, lease_duration); err != nil {
+		t.Fatal(err)
+	}
+	db.UpdateTaskStatus(task.Id, db.Running)
+
+	return task.Id
+}
+
+// Helper to time out the task and to return all of its attempts.
+func timeOutAndGetAttempts(t *testing.T, tid int64) []*db.Task {
+	api.timeOutTask(tid, "The execution exceeded its deadline.")
+
+	task, err := db.GetTaskById(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !task.IsTimedOut() {
+		t.Errorf("status %s, want timed out", task.StatusString())
+	}
+	if task.Cancel_reason != "The execution exceeded its deadline." {
+		t.Errorf("reason %q was not kept", task.Cancel_reason)
+	}
+
+	attempts, err := db.GetTaskAttempts(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return attempts
+}
+
+func TestTimedOutTaskIsRetried(t *testing.T) {
+	setUpTestDB(t)
+	tid := createRunningTask(t, 1)
+
+	attempts := timeOutAndGetAttempts(t, tid)
+	if len(attempts) != 2 {
+		t.Fatalf("%d attempts, want 2", len(attempts))
+	}
+	retry := attempts[1]
+	if !retry.IsPending() || retry.Attempt != 2 || retry.Parent != tid {
+		t.Errorf("retry %s (attempt %d of task %d), want pending attempt 2 "+
+			"of task %d", retry.StatusString(), retry.Attempt, retry.Parent,
+			tid)
+	}
+	if retry.Not_before == nil {
+		t.Error("the retry is not delayed")
+	}
+}
+
+func TestTimedOutTaskWithoutRetriesLeft(t *testing.T) {
+	setUpTestDB(t)
+	tid := createRunningTask(t, 0)
+
+	if attempts := timeOutAndGetAttempts(t, tid); len(attempts) != 1 {
+		t.Errorf("%d attempts, want no retry", len(attempts))
+	}
+}
+
+func TestCreateTaskSkipsConcurrentOverlaps(t *testing.T) {
+	setUpTestDB(t)
+	tid := createRunningTask(t, 0)
+	task, err := db.GetTaskById(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.ReleaseTaskLease(tid)
+	db.UpdateTaskStatus(tid, db.Succeeded)
+	if _, err := test_db.Exec("UPDATE group_tasks SET overlap = $2 "+
+		"WHERE id = $1", task.Gid, db.Overlap_skip); err != nil {
+		t.Fatal(err)
+	}
+
+	// only one of the concurrent triggers creates an execution
+	var wait sync.WaitGroup
+	created := make(chan int64, 10)
+	for i := 0; i < cap(created); i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			if id, err := CreateNewTask(task.Gid); err == nil {
+				created <- id
+			} else if err != OverlappingExecution {
+				t.Error(err)
+			}
+		}()
+	}
+	wait.Wait()
+	if len(created) != 1 {
+		t.Errorf("%d executions created, want 1", len(created))
+	}
+}
+
+func TestStoppedSchedulerDoesNotWrite(t *testing.T) {
+	runningTasks = make(map[int64]chan bool)
+	stale := make(chan bool, 1)
+	runningTasks[1] = stale
+
+	ran := false
+	if !whileScheduling(1, stale, func() { ran = true }) || !ran {
+		t.Fatal("the registered go routine was stopped")
+	}
+
+	// restarting replaces the go routine, the stale one must not write
+	stopScheduler(1)
+	if len(stale) != 1 {
+		t.Error("the stopped go routine was not notified")
+	}
+	runningTasks[1] = make(chan bool, 1)
+	ran = false
+	if whileScheduling(1, stale, func() { ran = true }) || ran {
+		t.Error("the stopped go routine wrote")
+	}
+}
+
+// Helper to let the lease on the task expire, to reclaim it and to return all
+// attempts of the task.
+func reclaimAndGetAttempts(t *testing.T, tid int64) []*db.Task {
+	if _, err := test_db.Exec("UPDATE task_leases SET expires = now() - "+
+		"interval '1 minute' WHERE tid = $1", tid); err != nil {
+		t.Fatal(err)
+	}
+	ReapExpiredLeases()
+
+	task, err := db.GetTaskById(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if task.Status != db.Failed || !task.Infra_failure {
+		t.Errorf("status %s (infrastructure failure %t), want failed "+
+			"because of the infrastructure", task.StatusString(),
+			task.Infra_failure)
+	}
+
+	attempts, err := db.GetTaskAttempts(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return attempts
+}
+
+func TestReclaimedTaskIsRetried(t *testing.T) {
+	setUpTestDB(t)
+	tid := createRunningTask(t, 1)
+
+	attempts := reclaimAndGetAttempts(t, tid)
+	if len(attempts) != 2 {
+		t.Fatalf("%d attempts, want 2", len(attempts))
+	}
+	if retry := attempts[1]; !retry.IsPending() || retry.Attempt != 2 {
+		t.Errorf("retry %s (attempt %d), want pending attempt 2",
+			retry.StatusString(), retry.Attempt)
+	}
+}
+
+func TestReclaimedTaskWithoutRetriesLeft(t *testing.T) {
+	setUpTestDB(t)
+	tid := createRunningTask(t, 0)
+
+	if attempts := reclaimAndGetAttempts(t, tid); len(attempts) != 1 {
+		t.Errorf("%d attempts, want no retry", len(attempts))
+	}
+}
+
+func TestDuplicateResultIsRetriedOnce(t *testing.T) {
+	setUpTestDB(t)
+	tid := createRunningTask(t, 1)
+	api.running_workers[tid] = make(chan bool, 1)
+
+	// e.g. an HTTP worker retrying its request
+	var wait sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			var ack bool
+			api.PublishTaskResult(Result{Worker_token: "worker", Tid: tid,
+				Exit_status: 1, Infrastructure_failure: true}, &ack)
+		}()
+	}
+	wait.Wait()
+
+	task, err := db.GetTaskById(tid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	attempts, err := db.GetTaskAttempts(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attempts) != 2 {
+		t.Errorf("%d attempts, want 2", len(attempts))
+	}
+}